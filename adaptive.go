@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// fetchLimiter adapts how many requests are in flight when -concurrency-auto
+// is set, nil otherwise.
+var fetchLimiter *aimdLimiter
+
+const (
+	// Responses slower than this count as a sign of congestion
+	aimdSlowResponse = 3 * time.Second
+
+	// Only back off once per burst of failures
+	aimdBackoffCooldown = 2 * time.Second
+)
+
+// aimdLimiter is a concurrency limit tuned with additive increase,
+// multiplicative decrease: it starts at one request, grows by about one
+// request for every limit's worth of fast successes, and halves on a 429, a
+// 5xx, a network error or a slow response. The limit never exceeds max.
+type aimdLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    float64
+	max      float64
+	inFlight int
+	lastDrop time.Time
+}
+
+func newAIMDLimiter(max int) *aimdLimiter {
+	l := &aimdLimiter{limit: 1, max: float64(max)}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire blocks until a request may start, or returns ctx.Err() once ctx is
+// done. It returns immediately on a nil limiter.
+func (l *aimdLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight >= int(l.limit) {
+		// Wake the waiters when ctx is done, as a Cond can't wait on a channel
+		waited := make(chan struct{})
+		defer close(waited)
+		go func() {
+			select {
+			case <-ctx.Done():
+				l.mu.Lock()
+				l.cond.Broadcast()
+				l.mu.Unlock()
+			case <-waited:
+			}
+		}()
+	}
+	for l.inFlight >= int(l.limit) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		l.cond.Wait()
+	}
+	l.inFlight++
+	return nil
+}
+
+// release ends a request and adjusts the limit. congested reports whether the
+// request was throttled, failed or slow.
+func (l *aimdLimiter) release(latency time.Duration, congested bool) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+
+	if congested || latency > aimdSlowResponse {
+		if time.Since(l.lastDrop) > aimdBackoffCooldown {
+			l.limit /= 2
+			if l.limit < 1 {
+				l.limit = 1
+			}
+			l.lastDrop = time.Now()
+		}
+	} else {
+		l.limit += 1 / l.limit
+		if l.limit > l.max {
+			l.limit = l.max
+		}
+	}
+	l.cond.Broadcast()
+}
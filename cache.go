@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// responseCache holds fetched response bodies when -cache is set.
+var responseCache *diskCache
+
+// diskCache stores response bodies on disk, one file per request URL. Both
+// CDX listings and snapshots are immutable enough that a shared directory can
+// be warmed once with -fetch-only and reused by later offline runs.
+type diskCache struct {
+	dir string
+
+	// Bodies cached longer ago than ttl are fetched again, zero to keep them
+	// forever
+	ttl time.Duration
+
+	// Ignore every cached body but still store the fresh ones, for -no-cache
+	refresh bool
+}
+
+func (c *diskCache) path(requestURL string) string {
+	sum := sha256.Sum256([]byte(requestURL))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// get returns the cached body for requestURL. It always misses on a nil cache,
+// with -no-cache and once the body has expired.
+func (c *diskCache) get(requestURL string) ([]byte, bool) {
+	if c == nil || c.refresh {
+		return nil, false
+	}
+	filePath := c.path(requestURL)
+	if c.ttl > 0 {
+		info, err := os.Stat(filePath)
+		if err != nil || time.Since(info.ModTime()) > c.ttl {
+			return nil, false
+		}
+	}
+	body, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, false
+	}
+	return body, true
+}
+
+// put stores body for requestURL. It is a no-op on a nil cache.
+func (c *diskCache) put(requestURL string, body []byte) {
+	if c == nil {
+		return
+	}
+	filePath := c.path(requestURL)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating cache directory %s: %v\n", filepath.Dir(filePath), err)
+		return
+	}
+
+	// Write to a temporary file first so concurrent readers never see a partial body
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filePath), "*.tmp")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing cache file %s: %v\n", filePath, err)
+		return
+	}
+	_, err = tmpFile.Write(body)
+	tmpFile.Close()
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		fmt.Fprintf(os.Stderr, "Error writing cache file %s: %v\n", filePath, err)
+		return
+	}
+	if err := os.Rename(tmpFile.Name(), filePath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing cache file %s: %v\n", filePath, err)
+	}
+}
+
+// cacheValidators are the response headers a cached body can be revalidated
+// with through a conditional request.
+type cacheValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// getValidators returns the validators stored alongside the cached body of
+// requestURL. It always misses on a nil cache.
+func (c *diskCache) getValidators(requestURL string) (cacheValidators, bool) {
+	var v cacheValidators
+	if c == nil {
+		return v, false
+	}
+	raw, err := ioutil.ReadFile(c.path(requestURL) + ".meta")
+	if err != nil {
+		return v, false
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return v, false
+	}
+	return v, v.ETag != "" || v.LastModified != ""
+}
+
+// putValidators stores v next to the cached body of requestURL. When the
+// response had no validators, those of an older body are removed instead, so
+// the new body isn't revalidated against them. It is a no-op on a nil cache.
+func (c *diskCache) putValidators(requestURL string, v cacheValidators) {
+	if c == nil {
+		return
+	}
+	filePath := c.path(requestURL) + ".meta"
+	if v.ETag == "" && v.LastModified == "" {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error removing cache file %s: %v\n", filePath, err)
+		}
+		return
+	}
+	if err := writeJSONFile(filePath, v); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing cache file %s: %v\n", filePath, err)
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhmdiaa/waybackrobots/pkg/waybackrobots"
+)
+
+// httpClient is shared by every request the tool makes.
+var httpClient = http.DefaultClient
+
+// userAgent is sent with every archive request so archive.org can identify
+// the traffic. Set by -ua.
+var userAgent = "waybackrobots/" + version
+
+// blockPageRetries is how many times a request that got the archive's
+// rate-limit page is retried, waiting blockPageBackoff, then twice as long, ...
+const (
+	blockPageRetries = 3
+	blockPageBackoff = 10 * time.Second
+)
+
+// truncatedRetries is how many times a download that ended before its
+// Content-Length is retried.
+const truncatedRetries = 2
+
+// fetchRetries is how many times a request that failed with a network error
+// or a 5xx is retried, waiting retryBackoff, then twice as long, ... A 429 is
+// retried as many times on top, waiting as long as its Retry-After asks. Set
+// by -retries.
+var fetchRetries = 2
+
+const retryBackoff = 500 * time.Millisecond
+
+// rateLimitWait is how long to wait after a 429 that came without a
+// Retry-After header.
+const rateLimitWait = 5 * time.Second
+
+// maxRetryAfter caps the wait a Retry-After header can ask for, so a bogus
+// value doesn't stall a run for hours.
+const maxRetryAfter = 5 * time.Minute
+
+var (
+	errTruncated   = errors.New("truncated response")
+	errNotModified = errors.New("not modified")
+)
+
+// blockPageMarkers are phrases of the HTML page the archive serves with a 200
+// status when it throttles a client.
+var blockPageMarkers = [][]byte{
+	[]byte("you have been blocked"),
+	[]byte("you've been blocked"),
+	[]byte("too many requests"),
+	[]byte("please slow down"),
+	[]byte("rate limit"),
+}
+
+// fetch GETs requestURL and returns the body of a 200 response. When -cache is
+// set, cached bodies are returned without touching the network. Retries are
+// abandoned and the request aborted once ctx is done.
+func fetch(ctx context.Context, requestURL string) ([]byte, error) {
+	return fetchCached(ctx, requestURL, cacheUse)
+}
+
+// cacheMode is how fetchCached uses the response cache.
+type cacheMode int
+
+const (
+	cacheUse        cacheMode = iota // Reuse cached bodies as they are
+	cacheRevalidate                  // Reuse cached bodies once confirmed unchanged
+	cacheBypass                      // Neither read nor write the cache
+)
+
+// fetchCached is fetch with the cache used as mode says. With cacheRevalidate,
+// a cached body that came with an ETag or Last-Modified header is only reused
+// once a conditional request confirms it is unchanged, or when the archive
+// can't be reached at all. A request is counted as one failure when it gives
+// up, however many attempts it took.
+func fetchCached(ctx context.Context, requestURL string, mode cacheMode) ([]byte, error) {
+	var cached []byte
+	isCached := false
+	if mode != cacheBypass {
+		cached, isCached = responseCache.get(requestURL)
+	}
+	var validators cacheValidators
+	if isCached {
+		v, ok := responseCache.getValidators(requestURL)
+		if mode == cacheUse || !ok {
+			verbosef("Using cached %s", requestURL)
+			return cached, nil
+		}
+		validators = v
+	}
+
+	backoff, retryDelay := blockPageBackoff, retryBackoff
+	throttled, truncated, failed, rateLimited := 0, 0, 0, 0
+	for {
+		if err := fetchLimiter.acquire(ctx); err != nil {
+			return nil, err
+		}
+		started := time.Now()
+		body, newValidators, err := fetchOnce(ctx, requestURL, validators)
+		fetchLimiter.release(time.Since(started), isCongestion(err))
+		if errors.Is(err, errNotModified) {
+			return cached, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil && isCached {
+			// Keep offline runs over a warm cache working
+			return cached, nil
+		}
+		if errors.Is(err, errTruncated) {
+			if truncated == truncatedRetries {
+				stats.failures.Add(1)
+				return nil, err
+			}
+			truncated++
+			fmt.Fprintf(os.Stderr, "Retrying %s in %s: %v\n", requestURL, retryDelay, err)
+			if !sleepInterruptible(ctx, retryDelay) {
+				return nil, ctx.Err()
+			}
+			retryDelay *= 2
+			continue
+		}
+		var statusErr *statusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests && rateLimited < fetchRetries {
+			rateLimited++
+			wait := statusErr.RetryAfter
+			if wait <= 0 {
+				wait = rateLimitWait
+			}
+			fmt.Fprintf(os.Stderr, "Rate limited by the archive, retrying %s in %s\n", requestURL, wait)
+			if !sleepInterruptible(ctx, wait) {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		if isTransient(err) && failed < fetchRetries {
+			failed++
+			fmt.Fprintf(os.Stderr, "Retrying %s in %s: %v\n", requestURL, retryDelay, err)
+			if !sleepInterruptible(ctx, retryDelay) {
+				return nil, ctx.Err()
+			}
+			retryDelay *= 2
+			continue
+		}
+		if err != nil {
+			stats.failures.Add(1)
+			return nil, err
+		}
+		if mode == cacheBypass {
+			// Not served by the archive, so not its block page either
+			return body, nil
+		}
+		if !isBlockPage(body) {
+			responseCache.put(requestURL, body)
+			responseCache.putValidators(requestURL, newValidators)
+			return body, nil
+		}
+		if throttled == blockPageRetries {
+			stats.failures.Add(1)
+			return nil, fmt.Errorf("still throttled by the archive after %d retries: %s", blockPageRetries, requestURL)
+		}
+		throttled++
+		fmt.Fprintf(os.Stderr, "Throttled by the archive, retrying %s in %s\n", requestURL, backoff)
+		if !sleepInterruptible(ctx, backoff) {
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// fetchOnce performs a single GET of requestURL, bypassing the cache. When
+// validators are given the request is conditional and errNotModified is
+// returned for a 304. The validators of the response are returned with its body.
+func fetchOnce(ctx context.Context, requestURL string, validators cacheValidators) ([]byte, cacheValidators, error) {
+	var none cacheValidators
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, none, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		verbosef("GET %s: %v", requestURL, err)
+		return nil, none, err
+	}
+	defer res.Body.Close()
+	verbosef("GET %s: %d", requestURL, res.StatusCode)
+
+	if res.StatusCode == http.StatusNotModified {
+		return nil, none, errNotModified
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, none, &statusError{StatusError: waybackrobots.StatusError{StatusCode: res.StatusCode, URL: requestURL}, RetryAfter: retryAfter(res.Header.Get("Retry-After"))}
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, none, fmt.Errorf("%w: connection closed after %d bytes", errTruncated, len(body))
+	}
+	if err != nil {
+		return nil, none, err
+	}
+	// A dropped connection can also look like a clean EOF, which would yield
+	// an incomplete ruleset and spurious "removed" diffs
+	if res.ContentLength >= 0 && !res.Uncompressed && int64(len(body)) != res.ContentLength {
+		return nil, none, fmt.Errorf("%w: got %d of %d bytes", errTruncated, len(body), res.ContentLength)
+	}
+	return gunzipBody(body), cacheValidators{ETag: res.Header.Get("ETag"), LastModified: res.Header.Get("Last-Modified")}, nil
+}
+
+// gunzipBody decompresses body if it is gzip data. The transport only
+// decompresses responses it asked to be compressed, while some captures were
+// archived compressed and are replayed as-is with a Content-Encoding header.
+// Bodies that merely start like gzip are returned unchanged.
+func gunzipBody(body []byte) []byte {
+	if len(body) < 2 || body[0] != 0x1f || body[1] != 0x8b {
+		return body
+	}
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return body
+	}
+	decompressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return body
+	}
+	return decompressed
+}
+
+// isCongestion reports whether err means the archive or the network is
+// overloaded: a network error, a 429 or a 5xx.
+func isCongestion(err error) bool {
+	if err == nil || errors.Is(err, errNotModified) {
+		return false
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+// isTransient reports whether a request that failed with err may succeed
+// when repeated: a network error or a 5xx.
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, errNotModified) || errors.Is(err, errTruncated) {
+		return false
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+// statusError is returned for a response with an unexpected status code.
+type statusError struct {
+	waybackrobots.StatusError
+	RetryAfter time.Duration // Zero when the response had no Retry-After header
+}
+
+// Unwrap lets the waybackrobots package find the status code.
+func (e *statusError) Unwrap() error { return &e.StatusError }
+
+// retryAfter parses a Retry-After header, which is either a number of seconds
+// or an HTTP date, capped at maxRetryAfter. It returns zero for an empty or
+// malformed value and for a date in the past.
+func retryAfter(value string) time.Duration {
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		if seconds > int(maxRetryAfter/time.Second) {
+			return maxRetryAfter
+		}
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		wait = time.Until(date)
+	}
+	if wait < 0 {
+		return 0
+	}
+	if wait > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return wait
+}
+
+// isBlockPage reports whether body is the archive's rate-limit page rather
+// than the requested content.
+func isBlockPage(body []byte) bool {
+	if !waybackrobots.IsHTMLPage(body) {
+		return false
+	}
+	lower := bytes.ToLower(body)
+	for _, marker := range blockPageMarkers {
+		if bytes.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// cdxSlots limits how many CDX queries run at once across all domains, nil
+// for no limit beyond the domain concurrency.
+var cdxSlots chan struct{}
+
+// fetchCDX is fetch for CDX queries, which are throttled separately from
+// snapshot downloads by -workers-cdx. Unlike snapshots, CDX listings grow
+// over time, so cached listings are revalidated with a conditional request.
+func fetchCDX(ctx context.Context, requestURL string) ([]byte, error) {
+	verbosef("CDX query %s", requestURL)
+	if cdxSlots != nil {
+		cdxSlots <- struct{}{}
+		defer func() { <-cdxSlots }()
+	}
+	return fetchCached(ctx, requestURL, cacheRevalidate)
+}
+
+// newHTTPClient builds the shared client. opts.resolver is either empty (system
+// resolver), a plain DNS server such as "1.1.1.1:53", or a DNS-over-HTTPS
+// endpoint such as "https://1.1.1.1/dns-query".
+func newHTTPClient(opts options) (*http.Client, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	if opts.resolver != "" {
+		resolver, err := newResolver(opts.resolver)
+		if err != nil {
+			return nil, err
+		}
+		dialer.Resolver = resolver
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// Nearly every request goes to web.archive.org, so the per-host idle
+	// limit is what decides how many connections get reused
+	transport.MaxIdleConns = opts.maxIdleConns
+	transport.MaxIdleConnsPerHost = opts.maxIdleConns
+	transport.MaxConnsPerHost = opts.maxConnsPerHost
+
+	// The cloned transport already honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	if opts.proxy != "" {
+		proxyURL, err := url.Parse(opts.proxy)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q, expected e.g. http://127.0.0.1:8080", opts.proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if opts.insecure {
+		// For proxies that intercept TLS with their own certificate
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &http.Client{
+		Transport:     transport,
+		CheckRedirect: checkRedirect(opts.maxRedirects),
+		// Covers the whole request including reading the body, so a hung
+		// connection can't stall a worker forever
+		Timeout: time.Duration(opts.timeout) * time.Second,
+	}, nil
+}
+
+// checkRedirect stops following redirects after maxRedirects hops. With a
+// limit of 0 the 3xx response itself is returned to the caller.
+func checkRedirect(maxRedirects int) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if maxRedirects <= 0 {
+			return http.ErrUseLastResponse
+		}
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		return nil
+	}
+}
+
+func newResolver(resolverAddr string) (*net.Resolver, error) {
+	if strings.HasPrefix(resolverAddr, "https://") {
+		dohClient := &http.Client{Timeout: 10 * time.Second}
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return &dohConn{ctx: ctx, endpoint: resolverAddr, client: dohClient}, nil
+			},
+		}, nil
+	}
+
+	if _, _, err := net.SplitHostPort(resolverAddr); err != nil {
+		// Assume the standard DNS port when none is given
+		resolverAddr = net.JoinHostPort(resolverAddr, "53")
+	}
+	if _, _, err := net.SplitHostPort(resolverAddr); err != nil {
+		return nil, fmt.Errorf("invalid resolver address %q: %v", resolverAddr, err)
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, resolverAddr)
+		},
+	}, nil
+}
+
+// dohConn adapts a DNS-over-HTTPS endpoint to the stream connection the Go
+// resolver expects. Each length-prefixed query written to the connection is
+// POSTed to the endpoint, and the answer is made available to Read with the
+// same length prefix.
+type dohConn struct {
+	ctx      context.Context
+	endpoint string
+	client   *http.Client
+
+	query    bytes.Buffer
+	response bytes.Buffer
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	c.query.Write(b)
+
+	raw := c.query.Bytes()
+	if len(raw) < 2 {
+		return len(b), nil
+	}
+	msgLen := int(binary.BigEndian.Uint16(raw[:2]))
+	if len(raw) < 2+msgLen {
+		return len(b), nil
+	}
+
+	msg := make([]byte, msgLen)
+	copy(msg, raw[2:2+msgLen])
+	c.query.Next(2 + msgLen)
+
+	answer, err := c.exchange(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := make([]byte, 2)
+	binary.BigEndian.PutUint16(prefix, uint16(len(answer)))
+	c.response.Write(prefix)
+	c.response.Write(answer)
+	return len(b), nil
+}
+
+func (c *dohConn) exchange(msg []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.endpoint, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS-over-HTTPS query failed with status %d", res.StatusCode)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.response.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.response.Read(b)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr{} }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr{} }
+func (c *dohConn) SetDeadline(t time.Time) error      { return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type dohAddr struct{}
+
+func (dohAddr) Network() string { return "doh" }
+func (dohAddr) String() string  { return "doh" }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// combinedPaths collects the paths of every domain for -combined, nil when
+// each domain gets its own output.
+var combinedPaths *combinedOutput
+
+type combinedOutput struct {
+	mu      sync.Mutex
+	domains map[string]map[string]bool // K: domain, V: set of paths
+}
+
+func newCombinedOutput() *combinedOutput {
+	return &combinedOutput{domains: make(map[string]map[string]bool)}
+}
+
+// add records the paths of a domain. A domain processed more than once, e.g.
+// from two inputs, gets the union of its paths. It is a no-op on a nil output.
+func (c *combinedOutput) add(domain string, paths map[string]string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.domains[domain] == nil {
+		c.domains[domain] = make(map[string]bool)
+	}
+	for path := range paths {
+		c.domains[domain][path] = true
+	}
+}
+
+// write saves the sorted paths of every domain as a single JSON object keyed
+// by domain.
+func (c *combinedOutput) write(filePath string) {
+	if c == nil {
+		return
+	}
+	combined := make(map[string][]string, len(c.domains))
+	for domain, paths := range c.domains {
+		pathList := make([]string, 0, len(paths))
+		for path := range paths {
+			pathList = append(pathList, path)
+		}
+		sort.Strings(pathList)
+		combined[domain] = pathList
+	}
+	if err := writeJSONFile(filePath, combined); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing JSON to %s: %v\n", filePath, err)
+	} else {
+		infof("Wrote paths of %d domains to %s\n", len(combined), filePath)
+	}
+}
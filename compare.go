@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mhmdiaa/waybackrobots/pkg/waybackrobots"
+	"github.com/schollz/progressbar/v3"
+)
+
+// compareDomains diffs the latest archived robots.txt of two hosts, such as
+// staging and production. Paths are compared without their host, so a "+"
+// marks a rule only the second host has and a "-" one only the first has.
+func compareDomains(ctx context.Context, firstURL, secondURL string) {
+	bar := newProgressBar(2, "comparing domains")
+
+	first, err := latestRules(ctx, firstURL, bar)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error fetching latest robots.txt for %s: %v\n", firstURL, err)
+		return
+	}
+	second, err := latestRules(ctx, secondURL, bar)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error fetching latest robots.txt for %s: %v\n", secondURL, err)
+		return
+	}
+
+	agents := make([]string, 0, len(first)+len(second))
+	for agent := range first {
+		agents = append(agents, agent)
+	}
+	for agent := range second {
+		if _, exists := first[agent]; !exists {
+			agents = append(agents, agent)
+		}
+	}
+	sort.Strings(agents)
+
+	fmt.Printf("\n--- %s vs %s ---\n", firstURL, secondURL)
+	identical := true
+	for _, agent := range agents {
+		firstRules, inFirst := first[agent]
+		secondRules, inSecond := second[agent]
+		if !inSecond {
+			fmt.Printf("  [-] User-agent only in %s: %s\n", firstURL, agent)
+			identical = false
+			continue
+		}
+		if !inFirst {
+			fmt.Printf("  [+] User-agent only in %s: %s\n", secondURL, agent)
+			identical = false
+			continue
+		}
+
+		addedAllows, removedAllows, addedDisallows, removedDisallows := waybackrobots.DiffRuleSets(secondRules, firstRules)
+		if len(addedAllows) == 0 && len(removedAllows) == 0 && len(addedDisallows) == 0 && len(removedDisallows) == 0 {
+			continue
+		}
+		identical = false
+		fmt.Printf("  [~] Differing User-agent: %s\n", agent)
+		if len(addedAllows) > 0 || len(removedAllows) > 0 {
+			fmt.Println("    Allow:")
+			for _, path := range addedAllows {
+				fmt.Printf("      + %s\n", path)
+			}
+			for _, path := range removedAllows {
+				fmt.Printf("      - %s\n", path)
+			}
+		}
+		if len(addedDisallows) > 0 || len(removedDisallows) > 0 {
+			fmt.Println("    Disallow:")
+			for _, path := range addedDisallows {
+				fmt.Printf("      + %s\n", path)
+			}
+			for _, path := range removedDisallows {
+				fmt.Printf("      - %s\n", path)
+			}
+		}
+	}
+	if identical {
+		fmt.Println("  No differences")
+	}
+}
+
+// latestRules returns the rules of the most recent archived robots.txt of
+// rawURL, keyed by path without the host.
+func latestRules(ctx context.Context, rawURL string, bar *progressbar.ProgressBar) (AgentRules, error) {
+	u, vc, err := latestVersion(ctx, rawURL, bar)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make(AgentRules)
+	for agent, ruleSet := range vc.Rules {
+		rules[agent] = make(RuleSet)
+		for path, directive := range ruleSet {
+			rules[agent][strings.TrimPrefix(path, u)] = directive
+		}
+	}
+	return rules, nil
+}
+
+// latestVersion fetches and parses the most recent archived robots.txt of
+// rawURL. It also returns the cleaned base URL.
+func latestVersion(ctx context.Context, rawURL string, bar *progressbar.ProgressBar) (string, VersionContent, error) {
+	u, err := cleanURL(rawURL)
+	if err != nil {
+		return "", VersionContent{}, err
+	}
+
+	captures, err := archive.GetRobotsTxtCaptures(ctx, u, VersionQuery{Limit: 1, Recent: true})
+	if err != nil {
+		return "", VersionContent{}, err
+	}
+	if len(captures) == 0 {
+		return "", VersionContent{}, fmt.Errorf("no archived robots.txt")
+	}
+
+	capture := captures[len(captures)-1]
+	latest := capture.Timestamp
+	parsed, rawContent, _ := fetchRules(ctx, latest, u, capture.Original, bar)
+	if parsed.Rules == nil {
+		return "", VersionContent{}, fmt.Errorf("latest snapshot %s could not be used", latest)
+	}
+	return u, VersionContent{Timestamp: latest, URL: u, Original: capture.Original, Rules: parsed.Rules, Order: parsed.Order, Delays: parsed.Delays, RawContent: rawContent}, nil
+}
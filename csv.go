@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// pathRow is a row of -format csv: a path with the directive and user-agent
+// of the rule it came from.
+type pathRow struct {
+	path      string
+	directive string
+	agent     string
+}
+
+// csvHeaderWritten records whether stdout already got the CSV header. Only
+// touched on the output writer goroutine.
+var csvHeaderWritten bool
+
+// writePathsCSV writes the rows of u with the timestamp of the earliest
+// snapshot each was seen in, to paths.csv next to the other output or to
+// stdout. On stdout the header is only written before the first domain, so
+// the output of a whole run is a single CSV document.
+func writePathsCSV(u string, source string, rows map[pathRow]string, opts options) {
+	domain := getHost(u)
+	if len(rows) == 0 && !opts.writeEmpty {
+		infof("No paths found for %s\n", domain)
+		return
+	}
+
+	sorted := make([]pathRow, 0, len(rows))
+	for row := range rows {
+		sorted = append(sorted, row)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].path != sorted[j].path {
+			return sorted[i].path < sorted[j].path
+		}
+		if sorted[i].directive != sorted[j].directive {
+			return sorted[i].directive < sorted[j].directive
+		}
+		return sorted[i].agent < sorted[j].agent
+	})
+
+	header := []string{"path", "directive", "user_agent", "first_seen"}
+	if opts.withSource {
+		header = append([]string{"source"}, header...)
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if opts.outputDir != "" || !csvHeaderWritten {
+		w.Write(header)
+		csvHeaderWritten = opts.outputDir == ""
+	}
+	for _, row := range sorted {
+		record := []string{row.path, row.directive, row.agent, rows[row]}
+		if opts.withSource {
+			record = append([]string{source}, record...)
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing CSV for %s: %v\n", domain, err)
+		return
+	}
+
+	if opts.outputDir == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+
+	dirPath := filepath.Join(opts.outputDir, domain)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", dirPath, err)
+		return
+	}
+	filePath := filepath.Join(dirPath, "paths.csv")
+	if err := writeFileAtomic(filePath, buf.Bytes()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing CSV to %s: %v\n", filePath, err)
+	} else {
+		infof("Wrote paths to %s\n", filePath)
+	}
+}
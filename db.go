@@ -0,0 +1,200 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/mhmdiaa/waybackrobots/pkg/waybackrobots"
+	_ "modernc.org/sqlite" // Pure Go, so builds still work without cgo
+)
+
+// resultDB receives paths and timeline changes when -db is set.
+var resultDB *sqliteDB
+
+const dbSchema = `
+CREATE TABLE IF NOT EXISTS paths (
+	domain         TEXT NOT NULL,
+	url            TEXT NOT NULL,
+	directive      TEXT NOT NULL,
+	first_seen     TEXT NOT NULL,
+	last_seen      TEXT NOT NULL,
+	snapshot_count INTEGER NOT NULL,
+	PRIMARY KEY (domain, url, directive)
+);
+CREATE TABLE IF NOT EXISTS timeline_changes (
+	domain     TEXT NOT NULL,
+	timestamp  TEXT NOT NULL,
+	user_agent TEXT NOT NULL,
+	directive  TEXT NOT NULL,
+	url        TEXT NOT NULL,
+	change     TEXT NOT NULL, -- "added" or "removed"
+	UNIQUE (domain, timestamp, user_agent, url, change)
+);
+`
+
+// sqliteDB writes results of every domain into one SQLite file so a whole
+// corpus of scans can be queried with SQL.
+type sqliteDB struct {
+	mu sync.Mutex // SQLite allows a single writer at a time
+	db *sql.DB
+}
+
+// pathSighting aggregates the snapshots a path was seen in.
+type pathSighting struct {
+	firstSeen string
+	lastSeen  string
+	snapshots int
+}
+
+func openDB(filePath string) (*sqliteDB, error) {
+	db, err := sql.Open("sqlite", filePath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(dbSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteDB{db: db}, nil
+}
+
+func (d *sqliteDB) close() {
+	if d == nil {
+		return
+	}
+	if err := d.db.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing database: %v\n", err)
+	}
+}
+
+// writePaths upserts the paths of domain, widening the seen range and adding
+// to the snapshot count of paths stored by earlier runs. It is a no-op on a
+// nil database.
+func (d *sqliteDB) writePaths(domain string, sightings map[robotsPath]*pathSighting) {
+	if d == nil || len(sightings) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.inTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT INTO paths (domain, url, directive, first_seen, last_seen, snapshot_count)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (domain, url, directive) DO UPDATE SET
+				first_seen = MIN(first_seen, excluded.first_seen),
+				last_seen = MAX(last_seen, excluded.last_seen),
+				snapshot_count = snapshot_count + excluded.snapshot_count`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for rp, s := range sightings {
+			if _, err := stmt.Exec(domain, rp.URL, rp.Directive, s.firstSeen, s.lastSeen, s.snapshots); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing paths of %s to database: %v\n", domain, err)
+	}
+}
+
+// dbChange is a single rule added or removed in a timeline version.
+type dbChange struct {
+	timestamp string
+	agent     string
+	directive string
+	url       string
+	change    string
+}
+
+// timelineChanges diffs vc against previous the same way the timeline does,
+// flattened into one row per added or removed rule. An agent that appears or
+// disappears contributes all of its rules.
+func timelineChanges(vc VersionContent, previous AgentRules) []dbChange {
+	var changes []dbChange
+	add := func(agent, change string, rules RuleSet) {
+		for path, directive := range rules {
+			changes = append(changes, dbChange{vc.Timestamp, agent, directive, path, change})
+		}
+	}
+
+	for agent, rules := range vc.Rules {
+		prevRules, exists := previous[agent]
+		if !exists {
+			add(agent, "added", rules)
+			continue
+		}
+		addedAllows, removedAllows, addedDisallows, removedDisallows := waybackrobots.DiffRuleSets(rules, prevRules)
+		for _, path := range addedAllows {
+			changes = append(changes, dbChange{vc.Timestamp, agent, "allow", path, "added"})
+		}
+		for _, path := range removedAllows {
+			changes = append(changes, dbChange{vc.Timestamp, agent, "allow", path, "removed"})
+		}
+		for _, path := range addedDisallows {
+			changes = append(changes, dbChange{vc.Timestamp, agent, "disallow", path, "added"})
+		}
+		for _, path := range removedDisallows {
+			changes = append(changes, dbChange{vc.Timestamp, agent, "disallow", path, "removed"})
+		}
+	}
+	for agent, rules := range previous {
+		if _, exists := vc.Rules[agent]; !exists {
+			add(agent, "removed", rules)
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].agent != changes[j].agent {
+			return changes[i].agent < changes[j].agent
+		}
+		return changes[i].url < changes[j].url
+	})
+	return changes
+}
+
+// writeChanges records timeline changes of domain. Changes stored by an
+// earlier run of the same domain are kept once. It is a no-op on a nil
+// database.
+func (d *sqliteDB) writeChanges(domain string, changes []dbChange) {
+	if d == nil || len(changes) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.inTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT OR IGNORE INTO timeline_changes (domain, timestamp, user_agent, directive, url, change)
+			VALUES (?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, c := range changes {
+			if _, err := stmt.Exec(domain, c.timestamp, c.agent, c.directive, c.url, c.change); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing timeline of %s to database: %v\n", domain, err)
+	}
+}
+
+func (d *sqliteDB) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// dotTemplate renders the disallowed paths of every user-agent as a GraphViz
+// graph. Agents and paths are both nodes, so a path disallowed for several
+// agents shows up once with an edge from each of them.
+var dotTemplate = template.Must(template.New("dot").Funcs(template.FuncMap{
+	"quote": dotQuote,
+}).Parse(`digraph robots {
+  label={{quote .Label}};
+  rankdir=LR;
+  node [shape=box];
+{{- range .Agents}}
+  {{quote .Node}} [label={{quote .Name}}, shape=ellipse];
+{{- end}}
+{{- range .Agents}}{{$agent := .Node}}
+{{- range .Paths}}
+  {{quote $agent}} -> {{quote .}};
+{{- end}}
+{{- end}}
+}
+`))
+
+// dotEscaper escapes the characters with a meaning inside a quoted DOT string.
+// Unlike strconv.Quote it leaves UTF-8 as is, which GraphViz reads natively.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// dotQuote returns s as a quoted DOT string.
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+type dotAgent struct {
+	Node  string
+	Name  string
+	Paths []string
+}
+
+// atOrBefore reports whether timestamp is at or before at, which may be a
+// timestamp prefix such as "2021" or "202106". An empty at matches every
+// timestamp, so the last match of a sorted history is its latest version.
+func atOrBefore(timestamp, at string) bool {
+	if at == "" {
+		return true
+	}
+	if len(at) < len(timestamp) {
+		timestamp = timestamp[:len(at)]
+	}
+	return timestamp <= at
+}
+
+// writeDot writes the agent/path graph of vc to robots.dot next to the
+// timeline output, or prints it to stdout when no output directory is set.
+func writeDot(u string, vc VersionContent, opts options) {
+	agents := make([]dotAgent, 0, len(vc.Rules))
+	for agent, rules := range vc.Rules {
+		paths := []string{}
+		for path, directive := range rules {
+			if directive == "disallow" {
+				paths = append(paths, path)
+			}
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		sort.Strings(paths)
+		// Prefix agent nodes so an agent can't collide with a path node
+		agents = append(agents, dotAgent{Node: "agent:" + agent, Name: agent, Paths: paths})
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].Name < agents[j].Name
+	})
+
+	var buf bytes.Buffer
+	err := dotTemplate.Execute(&buf, struct {
+		Label  string
+		Agents []dotAgent
+	}{
+		Label:  fmt.Sprintf("%s/robots.txt at %s", u, vc.Timestamp),
+		Agents: agents,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error rendering DOT graph for %s: %v\n", u, err)
+		return
+	}
+
+	if opts.outputDir == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+
+	dirPath := timelineDir(u, opts)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", dirPath, err)
+		return
+	}
+	filePath := filepath.Join(dirPath, "robots.dot")
+	if err := writeFileAtomic(filePath, buf.Bytes()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing DOT graph to %s: %v\n", filePath, err)
+	} else {
+		infof("Wrote DOT graph to %s\n", filePath)
+	}
+}
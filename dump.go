@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// cdxDump receives every raw CDX response when -dump-cdx is set.
+var cdxDump *cdxDumper
+
+// cdxDumper appends raw CDX responses to a single file. Domains are processed
+// concurrently, so writes are serialized to keep each response in one piece.
+type cdxDumper struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// dump records the query and its raw response. It is a no-op on a nil dumper.
+func (d *cdxDumper) dump(requestURL string, raw []byte) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, err := fmt.Fprintf(d.w, "# %s\n%s\n", requestURL, raw); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing CDX dump: %v\n", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// loadExcludePatterns reads -exclude-domains, which is either a file with one
+// pattern per line or a comma-separated list of patterns.
+func loadExcludePatterns(spec string) ([]string, error) {
+	list := spec
+	if raw, err := ioutil.ReadFile(spec); err == nil {
+		list = strings.ReplaceAll(string(raw), "\n", ",")
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var patterns []string
+	for _, pattern := range strings.Split(list, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// isExcluded reports whether host matches one of the patterns. A pattern is
+// either an exact host or "*.example.com", which matches every subdomain of
+// example.com but not example.com itself.
+func isExcluded(host string, patterns []string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// excludeURLs drops the inputs whose normalized host matches patterns.
+func excludeURLs(urls []string, patterns []string) []string {
+	if len(patterns) == 0 {
+		return urls
+	}
+	kept := make([]string, 0, len(urls))
+	for _, rawURL := range urls {
+		u, err := cleanURL(rawURL)
+		if err == nil && isExcluded(getHost(u), patterns) {
+			infof("Skipping excluded domain %s\n", getHost(u))
+			continue
+		}
+		kept = append(kept, rawURL)
+	}
+	return kept
+}
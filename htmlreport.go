@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+
+	"github.com/mhmdiaa/waybackrobots/pkg/waybackrobots"
+)
+
+// htmlTemplate renders a timeline as a single page without external assets.
+// Every change date is a collapsible section; html/template escapes the
+// paths and user-agents, which come straight from archived files.
+var htmlTemplate = template.Must(template.New("timeline").Funcs(template.FuncMap{
+	"day":      formatDay,
+	"snapshot": func(u, timestamp, original string) string { return waybackrobots.SnapshotURL(timestamp, u, original) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.URL}}/robots.txt timeline</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+details { border: 1px solid #ccc; border-radius: 4px; margin: 0.5em 0; padding: 0.5em 1em; }
+summary { cursor: pointer; font-weight: bold; }
+ul { list-style: none; padding-left: 1em; }
+code { font-family: monospace; }
+.added { color: #1a7f37; }
+.removed { color: #cf222e; }
+.flagged { color: #9a6700; }
+</style>
+</head>
+<body>
+<h1>{{.URL}}/robots.txt</h1>
+<p>{{len .Entries}} changes</p>
+{{- range .Entries}}
+<details open>
+<summary>{{day .Timestamp}} <code>{{.Timestamp}}</code></summary>
+<p><a href="{{snapshot $.URL .Timestamp .Original}}">Archived robots.txt</a></p>
+{{- if .FlaggedAgents}}
+<p class="flagged">Flagged user-agents: {{range $i, $a := .FlaggedAgents}}{{if $i}}, {{end}}<code>{{$a}}</code>{{end}}</p>
+{{- end}}
+{{- range .AgentsAdded}}
+<p class="added">+ User-agent: <code>{{.}}</code></p>
+{{- end}}
+{{- range .AgentsRemoved}}
+<p class="removed">- User-agent: <code>{{.}}</code></p>
+{{- end}}
+{{- range .InitialContent}}{{template "change" .}}{{end}}
+{{- range .RuleChanges}}{{template "change" .}}{{end}}
+{{- if or .SitemapsAdded .SitemapsRemoved}}
+<ul>
+{{- range .SitemapsRemoved}}
+<li class="removed">- Sitemap: <code>{{.}}</code></li>
+{{- end}}
+{{- range .SitemapsAdded}}
+<li class="added">+ Sitemap: <code>{{.}}</code></li>
+{{- end}}
+</ul>
+{{- end}}
+{{- if .DelayChanges}}
+<ul>
+{{- range .DelayChanges}}
+<li>Crawl-delay for <code>{{.UserAgent}}</code>: {{.String}}</li>
+{{- end}}
+</ul>
+{{- end}}
+</details>
+{{- end}}
+</body>
+</html>
+{{define "change"}}
+<h3>User-agent: <code>{{.UserAgent}}</code></h3>
+<ul>
+{{- range .Allow.Removed}}
+<li class="removed">- Allow: <code>{{.}}</code></li>
+{{- end}}
+{{- range .Disallow.Removed}}
+<li class="removed">- Disallow: <code>{{.}}</code></li>
+{{- end}}
+{{- range .Allow.Added}}
+<li class="added">+ Allow: <code>{{.}}</code></li>
+{{- end}}
+{{- range .Disallow.Added}}
+<li class="added">+ Disallow: <code>{{.}}</code></li>
+{{- end}}
+</ul>
+{{- end}}
+`))
+
+// writeTimelineHTML renders the timeline entries of u to an HTML report.
+func writeTimelineHTML(filePath, u string, entries []timelineEntry) {
+	var buf bytes.Buffer
+	err := htmlTemplate.Execute(&buf, struct {
+		URL     string
+		Entries []timelineEntry
+	}{URL: u, Entries: entries})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error rendering HTML timeline for %s: %v\n", u, err)
+		return
+	}
+	if err := writeFileAtomic(filePath, buf.Bytes()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing HTML to %s: %v\n", filePath, err)
+	} else {
+		infof("Wrote HTML timeline to %s\n", filePath)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mhmdiaa/waybackrobots/pkg/waybackrobots"
+)
+
+// fetchLiveRules fetches the robots.txt the site serves today, bypassing both
+// the archive and the cache, with the same retries as snapshots. A site
+// without one has no rules.
+func fetchLiveRules(ctx context.Context, u string) (AgentRules, error) {
+	body, err := fetchCached(ctx, u+"/robots.txt", cacheBypass)
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == 404 {
+		infof("%s has no live robots.txt\n", u)
+		return AgentRules{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return waybackrobots.Parse(string(gunzipBody(body)), u).Rules, nil
+}
+
+// addArchivedRule adds a rule found in an archived version to rules, the
+// union of every archived version. When versions disagree, disallow wins.
+// Rules outside of any user-agent group are left out, as waybackrobots.Parse drops
+// them from the live file too.
+func addArchivedRule(rules AgentRules, rp robotsPath) {
+	if rp.Agent == "" {
+		return
+	}
+	if rules[rp.Agent] == nil {
+		rules[rp.Agent] = make(RuleSet)
+	}
+	if rules[rp.Agent][rp.URL] != "disallow" {
+		rules[rp.Agent][rp.URL] = rp.Directive
+	}
+}
+
+// diffLive compares the live rules against the archived ones. In every
+// change, added paths are only in the live file and removed paths only in
+// the archive.
+func diffLive(live, archived AgentRules) []ruleChange {
+	agents := make([]string, 0, len(live)+len(archived))
+	for agent := range live {
+		agents = append(agents, agent)
+	}
+	for agent := range archived {
+		if _, ok := live[agent]; !ok {
+			agents = append(agents, agent)
+		}
+	}
+	sort.Strings(agents)
+
+	var changes []ruleChange
+	for _, agent := range agents {
+		addedAllows, removedAllows, addedDisallows, removedDisallows := waybackrobots.DiffRuleSets(live[agent], archived[agent])
+		if len(addedAllows) == 0 && len(removedAllows) == 0 && len(addedDisallows) == 0 && len(removedDisallows) == 0 {
+			continue
+		}
+		changes = append(changes, ruleChange{
+			UserAgent: agent,
+			Allow:     changeSet{Added: addedAllows, Removed: removedAllows},
+			Disallow:  changeSet{Added: addedDisallows, Removed: removedDisallows},
+		})
+	}
+	return changes
+}
+
+// writeLiveDiff writes the live/archive comparison of u to live_diff.json, or
+// prints it when no output directory is set.
+func writeLiveDiff(u string, changes []ruleChange, opts options) {
+	if opts.outputDir != "" {
+		if changes == nil {
+			changes = []ruleChange{}
+		}
+		dirPath := filepath.Join(opts.outputDir, getHost(u))
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", dirPath, err)
+			return
+		}
+		filePath := filepath.Join(dirPath, "live_diff.json")
+		if err := writeJSONFile(filePath, changes); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing JSON to %s: %v\n", filePath, err)
+		} else {
+			infof("Wrote live diff to %s\n", filePath)
+		}
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n--- %s/robots.txt: archive vs live ---\n", u)
+	if len(changes) == 0 {
+		fmt.Fprintln(&b, "  No differences")
+	}
+	for _, change := range changes {
+		fmt.Fprintf(&b, "  User-agent: %s\n", change.UserAgent)
+		for _, path := range change.Allow.Removed {
+			fmt.Fprintf(&b, "    [-] Allow: %s (removed from live)\n", path)
+		}
+		for _, path := range change.Disallow.Removed {
+			fmt.Fprintf(&b, "    [-] Disallow: %s (removed from live)\n", path)
+		}
+		for _, path := range change.Allow.Added {
+			fmt.Fprintf(&b, "    [+] Allow: %s (newly added)\n", path)
+		}
+		for _, path := range change.Disallow.Added {
+			fmt.Fprintf(&b, "    [+] Disallow: %s (newly added)\n", path)
+		}
+	}
+	fmt.Print(b.String())
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// verboseLog and debugLog receive the -verbose and -debug diagnostics on
+// stderr. Both are nil unless enabled, and -debug implies -verbose.
+var verboseLog, debugLog *log.Logger
+
+// verbosef logs a -verbose message. It is a no-op without -verbose.
+func verbosef(format string, args ...interface{}) {
+	if verboseLog != nil {
+		verboseLog.Printf(format, args...)
+	}
+}
+
+// debugf logs a -debug message. It is a no-op without -debug.
+func debugf(format string, args ...interface{}) {
+	if debugLog != nil {
+		debugLog.Printf(format, args...)
+	}
+}
+
+// quiet is set by -quiet to hide progress bars and informational messages.
+// Errors and the paths on stdout are unaffected.
+var quiet bool
+
+// infof prints an informational message to stderr unless -quiet is set.
+func infof(format string, args ...interface{}) {
+	if !quiet {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
+// newProgressBar returns a progress bar on stderr, or one that renders
+// nothing with -quiet.
+func newProgressBar(max int64, description string) *progressbar.ProgressBar {
+	if quiet {
+		return progressbar.DefaultSilent(max, description)
+	}
+	return progressbar.Default(max, description)
+}
@@ -1,56 +1,621 @@
 package main
 
 import (
-	"archive/zip"
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"io"
+	"log"
+	"math/rand"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
+	"time"
 
+	"github.com/mhmdiaa/waybackrobots/pkg/waybackrobots"
 	"github.com/schollz/progressbar/v3"
 )
 
-// RuleSet holds the paths and their directive (allow/disallow) for a specific user-agent.
-type RuleSet map[string]string // Key: path, Value: "allow" or "disallow"
+// The types of the waybackrobots package the CLI works with throughout.
+type (
+	RuleSet        = waybackrobots.RuleSet
+	AgentRules     = waybackrobots.AgentRules
+	AgentDelays    = waybackrobots.AgentDelays
+	OrderedRule    = waybackrobots.OrderedRule
+	AgentOrder     = waybackrobots.AgentOrder
+	ParsedRobots   = waybackrobots.ParsedRobots
+	VersionContent = waybackrobots.VersionContent
+	VersionQuery   = waybackrobots.VersionQuery
+	Capture        = waybackrobots.Capture
+	robotsPath     = waybackrobots.Path
+)
 
-// AgentRules holds the rules for all user-agents in a robots.txt file.
-type AgentRules map[string]RuleSet // Key: user-agent
+// snapshot identifies a single archived robots.txt capture.
+type snapshot struct {
+	Timestamp string
+	URL       string // Base URL the capture was made under
+	Original  string // Exact robots.txt URL from CDX, empty if unknown
+}
 
-// VersionContent holds the timestamp, rules, and raw content from a robots.txt version.
-type VersionContent struct {
-	Timestamp  string
-	Rules      AgentRules
-	RawContent string // Store the raw text content
+// options holds the command-line settings shared by every domain worker.
+type options struct {
+	versionsLimit int
+	threads       int // Snapshot fetch goroutines per domain
+	recent        bool
+	timeline      bool
+	year          int
+	toYear        int // Last year of a -year range, equal to year for a single year
+	outputDir     string
+	writeEmpty    bool
+	envelope      bool
+
+	// HTTP client settings
+	resolver        string
+	maxRedirects    int
+	maxIdleConns    int
+	maxConnsPerHost int
+	timeout         int // Seconds
+	proxy           string
+	insecure        bool
+
+	// Incremental mode, nil unless -state is set
+	state *runState
+
+	mergeWWW bool
+
+	// Scheme of the captures queried: "http", "https", "both", or empty for
+	// the scheme of the input
+	scheme string
+
+	// Prefix stdout paths with the input domain they came from
+	withSource bool
+
+	// Path filters
+	minPathLength int
+	subtract      map[string]bool // Known paths from -subtract, see wordlistKey
+
+	// Drop the query string of paths, so /search?q= is output as /search
+	stripQuery bool
+
+	// Output paths without the scheme and host, e.g. /admin/login
+	relative bool
+
+	reverse      bool
+	endpoints    int
+	pathLifespan bool
+	ordered      bool
+	flipped      bool
+	ruleCounts   bool
+	rawDiff      bool // Unified diff of the raw content of consecutive versions
+
+	// Alternative output format, empty for the default plain/JSON output
+	format       string
+	dotVersion   string
+	flagAgents   []string // Lowercased watchlist from -flag-agents
+	agents       []string // Lowercased agents from -agent, the only ones in the timeline
+	cdxFile      string
+	cdxPageSize  int
+	latestPerDay bool
+	match        string // CDX matchType
+	dedupe       bool   // By content digest across the whole history
+	withSnapshot bool   // Output the replay link of every path
+	withSeen     bool   // Output the first and last snapshot of every path in paths.json
+	live         bool   // Diff the archived rules against the live robots.txt
+	include      regexpList
+	exclude      regexpList
+	from         string // Date range of the captures, see VersionQuery
+	to           string
+
+	// Name of the raw robots.txt archive in -year mode
+	zipName *template.Template
+
+	// Restrict path mode output to a single directive, empty for both
+	onlyDirective string
+
+	// Only populate the cache, skipping parsing and output
+	fetchOnly bool
+
+	// Only list the snapshot URLs that would be fetched
+	printSnapshotURLs bool
+
+	// Canonicalize the percent-encoding of paths so spellings collapse
+	normalize bool
+
+	// Only output the paths still blocked after Allow overrides
+	netDisallowed bool
+	sitemaps      bool
+
+	// Cut robots.txt patterns at their first wildcard
+	expandWildcards bool
+
+	// Random delay before each fetch worker starts, and optionally before
+	// each request, to avoid hitting the archive in lockstep
+	jitter           time.Duration
+	jitterPerRequest bool
+
+	// Minimum gap between consecutive timeline versions
+	minInterval time.Duration
 }
 
 func main() {
+	started := time.Now()
+
 	versionsLimit := flag.Int("limit", 10, "limit the number crawled snapshots. Use -1 for unlimited")
-	recent := flag.Bool("recent", true, "use the most recent snapshots without evenly distributing them")
+	threads := flag.Int("threads", 10, "number of snapshots of a domain fetched at once")
+	mode := flag.String("mode", "recent", "how -limit snapshots are picked: recent (the most recent ones) or distributed (spread evenly over the history)")
+	recent := flag.Bool("recent", true, "deprecated, same as -mode recent, or -mode distributed with -recent=false")
 	timeline := flag.Bool("timeline", false, "show a timeline of changes in robots.txt")
-	year := flag.Int("year", 0, "specify a year to fetch timeline changes for (e.g., 2023). Overrides -limit and -recent.")
+	var year yearRange
+	flag.Var(&year, "year", "specify a year (e.g., 2023) or range of years (e.g., 2019-2023) to fetch timeline changes for. Overrides -limit and -mode.")
 	outputDir := flag.String("output", "", "directory to save JSON and raw .txt output")
+	listFile := flag.String("list", "", "file of input URLs, one per line. Blank lines and lines starting with # are skipped. Stdin is read as well when piped")
 	concurrentDomains := flag.Int("concurrent", 10, "number of domains to process concurrently")
+	flag.IntVar(concurrentDomains, "concurrency", 10, "alias of -concurrent")
+	concurrencyAuto := flag.Bool("concurrency-auto", false, "start with one request in flight and adapt the number of concurrent requests to the archive's latency and errors, up to -concurrent times -threads")
+	cdxWorkers := flag.Int("workers-cdx", 0, "maximum number of CDX queries running at once, independent of snapshot fetching. Use 0 for no limit")
+	discoverSubdomains := flag.Bool("discover-subdomains", false, "discover subdomains with archived robots.txt files and process each of them")
+	envelope := flag.Bool("envelope", false, "wrap paths.json in an object with the domain, scan time, settings and version count")
+	writeEmpty := flag.Bool("write-empty", false, "write paths.json even when no paths were found")
+	resolver := flag.String("resolver", "", "custom DNS resolver (e.g., 1.1.1.1:53 or https://1.1.1.1/dns-query for DNS-over-HTTPS)")
+	proxy := flag.String("proxy", "", "proxy URL for every archive request (e.g., http://127.0.0.1:8080). Defaults to HTTP_PROXY/HTTPS_PROXY")
+	insecure := flag.Bool("insecure", false, "skip TLS certificate verification, e.g. behind an intercepting proxy")
+	ua := flag.String("ua", userAgent, "User-Agent header sent with every archive request")
+	retries := flag.Int("retries", 2, "number of times a request failing with a network error or a 5xx is retried, with exponential backoff starting at 500ms. Also caps the retries after a 429, which wait for its Retry-After")
+	timeout := flag.Int("timeout", 30, "timeout in seconds for every archive request, including reading the response. Use 0 for no timeout")
+	maxIdleConns := flag.Int("max-idle-conns", 100, "maximum number of idle keep-alive connections kept for reuse")
+	maxConnsPerHost := flag.Int("max-conns-per-host", 0, "maximum number of connections per host, including active ones. Use 0 for no limit")
+	maxRedirects := flag.Int("max-redirects", 10, "maximum number of redirects to follow. Use 0 to disable following redirects")
+	mergeWWW := flag.Bool("merge-www", false, "treat www. and apex hosts as one target, fetching both histories and merging the results")
+	flag.BoolVar(mergeWWW, "both-www", false, "alias of -merge-www")
+	scheme := flag.String("scheme", "", "scheme of the captures to query: http, https, or both to merge the http:// and https:// histories. Defaults to the scheme of the input (https if none)")
+	directive := flag.String("directive", "both", "only output paths from rules with this directive: allow, disallow or both")
+	allowOnly := flag.Bool("include-allow-only", false, "only output paths from Allow rules")
+	disallowOnly := flag.Bool("include-disallow-only", false, "only output paths from Disallow rules")
+	endpoints := flag.Int("endpoints", 0, "only fetch the N oldest and N newest snapshots. Overrides -limit and -mode")
+	zipName := flag.String("zip-name", "robots_txt_{{.Year}}.zip", "name of the raw robots.txt zip archive in -year mode. Supports {{.Domain}} and {{.Year}}")
+	onlyAgent := flag.String("agent", "", "comma-separated user-agents (e.g., *,Googlebot) to limit the timeline to, ignoring case. Changes to other agents aren't reported")
+	flagAgents := flag.String("flag-agents", "", "comma-separated user-agents (e.g., Nuclei,Nessus) to flag in the timeline when they gain rules")
+	format := flag.String("format", "", "output format. In path mode \"plain\" (default), \"json\" or \"csv\" (path,directive,user_agent,first_seen rows) or \"ndjson\" (one {url, directive, agent} object per line, streamed as paths are found). In timeline mode \"dot\" writes a GraphViz graph of user-agents and the paths they disallow, and \"html\" writes a timeline.html report next to timeline.json (requires -output)")
+	dotVersion := flag.String("dot-version", "", "timestamp or prefix (e.g., 2021) of the version to graph with -format dot. Defaults to the latest")
+	flipped := flag.Bool("flipped", false, "in timeline mode, output only the paths whose directive flipped between Allow and Disallow, with their flip history as JSON")
+	ruleCounts := flag.Bool("rule-counts", false, "in timeline mode, output the number of Allow and Disallow rules of every agent in every version as JSON")
+	rawDiff := flag.Bool("raw-diff", false, "in timeline mode, output a unified diff of the raw robots.txt between consecutive versions, to stdout or raw_diff.patch with -output")
+	ordered := flag.Bool("ordered", false, "in timeline mode, output the Allow/Disallow rules of every agent in file order as JSON")
+	pathLifespan := flag.Bool("path-lifespan", false, "in timeline mode, output the first-seen and last-seen timestamps of every disallowed path as JSON")
+	reverse := flag.Bool("reverse", false, "output timeline entries newest-first")
+	minPathLength := flag.Int("min-path-length", 0, "drop paths whose path component is shorter than this many characters")
+	relative := flag.Bool("relative", false, "in path mode, output paths relative to the site (/admin/login) instead of full URLs")
+	stripQuery := flag.Bool("strip-query", false, "in path mode, remove the query string from paths, so /search?q= and /search?sort= are output once as /search")
+	jitter := flag.Duration("jitter", 0, "maximum random delay before each fetch worker starts (e.g., 500ms)")
+	jitterPerRequest := flag.Bool("jitter-per-request", false, "also apply -jitter before every snapshot request")
+	minInterval := flag.Duration("min-interval", 0, "in timeline mode, drop versions captured less than this long after the previous kept one (e.g., 24h or 168h)")
+	var include, exclude regexpList
+	flag.Var(&include, "include", "only output paths matching this regular expression. Can be repeated, a path matching any of them is kept")
+	flag.Var(&exclude, "exclude", "drop paths matching this regular expression. Can be repeated, and wins over -include")
+	live := flag.Bool("live", false, "fetch the current robots.txt from the site itself and report which archived rules were removed from it and which rules are new, to stdout or live_diff.json with -output")
+	withSeen := flag.Bool("with-seen", false, "write paths.json as {url, first_seen, last_seen} objects with the timestamps of the first and last snapshot every path was found in")
+	withSnapshot := flag.Bool("with-snapshot", false, "output the Wayback Machine link of every path as of the earliest snapshot it was found in, tab-separated after the path or as {path, snapshot} objects in paths.json")
+	dedupe := flag.Bool("dedupe", false, "fetch each distinct robots.txt content only once, at its first capture, instead of once per change. Path mode only")
+	match := flag.String("match", "exact", "CDX match type of the robots.txt query: exact, prefix (also robots.txt URLs with a query string), host (any scheme or port) or domain (the host and all of its subdomains)")
+	from := flag.String("from", "", "only use snapshots from this date on (YYYYMMDD or YYYYMMDDhhmmss). With -to, overrides -limit and -mode")
+	to := flag.String("to", "", "only use snapshots up to this date (YYYYMMDD or YYYYMMDDhhmmss). With -from, overrides -limit and -mode")
+	latestPerDay := flag.Bool("latest-per-day", false, "keep only the last snapshot of each calendar day")
+	cdxPageSize := flag.Int("cdx-page-size", 0, "request CDX listings this many rows at a time instead of in one response, for hosts with huge capture histories (e.g., 5000). 0 disables paging")
+	cdxFile := flag.String("cdx-file", "", "read the snapshot list from a CDX JSON file instead of querying CDX")
+	withSource := flag.Bool("with-source", false, "prefix each stdout path with the input domain it came from, tab-separated")
+	excludeDomains := flag.String("exclude-domains", "", "file or comma-separated list of domains to skip. Supports wildcards like *.example.com")
+	dedupeInput := flag.Bool("dedupe-input", false, "skip input URLs that normalize to a host already seen in this run")
+	prefilter := flag.Bool("prefilter", false, "before crawling, drop domains that have no archived robots.txt")
+	prefilterOut := flag.String("prefilter-out", "", "write the domains kept by -prefilter to this file")
+	subtract := flag.String("subtract", "", "wordlist of known paths to remove from the output, leaving only novel ones")
+	expandWildcards := flag.Bool("expand-wildcards", false, "cut paths at their first \"*\" and drop a trailing \"$\" anchor, so patterns become usable base paths. With -output, the raw patterns are kept in wildcard_patterns.json")
+	sitemaps := flag.Bool("sitemaps", false, "also output the Sitemap URLs of every version, deduplicated, as extra lines on stdout or sitemaps.json with -output")
+	netDisallowed := flag.Bool("net-disallowed", false, "only output paths that stay disallowed after longest-match Allow overrides are applied per agent")
+	normalize := flag.Bool("normalize", false, "canonicalize path encoding so /my+path and /my%20path collapse into one entry. In path mode, also deduplicate paths case-insensitively and regardless of trailing slashes, keeping the first spelling found")
+	cacheDir := flag.String("cache", "", "directory to cache CDX responses and snapshots in")
+	cacheTTL := flag.Duration("cache-ttl", 0, "fetch cached responses again once they are older than this (e.g., 168h). 0 keeps them forever")
+	noCache := flag.Bool("no-cache", false, "ignore the responses in the -cache directory and replace them with fresh ones")
+	fetchOnly := flag.Bool("fetch-only", false, "only fetch CDX responses and snapshots into the -cache directory, skipping parsing and output")
+	printSnapshots := flag.Bool("print-snapshot-urls", false, "only list the archive URLs of the selected snapshots, without fetching them. Written to snapshot_urls.txt with -output")
+	dumpCDX := flag.String("dump-cdx", "", "append the raw CDX response of every query to this file for debugging")
+	verbose := flag.Bool("verbose", false, "log CDX queries, snapshot fetches, status codes and version/path counts to stderr")
+	debug := flag.Bool("debug", false, "like -verbose, and also log the raw content of snapshots that yield no rules")
+	logPrefix := flag.String("log-prefix", "waybackrobots: ", "prefix of the -verbose and -debug log lines")
+	flag.BoolVar(&quiet, "quiet", false, "hide progress bars and informational messages such as \"Wrote paths to ...\". Errors and stdout output are unaffected")
+	flag.BoolVar(&quiet, "silent", false, "alias of -quiet")
+	findShared := flag.Bool("find-shared", false, "group the input domains whose latest archived robots.txt is byte-identical")
+	compare := flag.Bool("compare-domains", false, "diff the latest archived robots.txt of exactly two input URLs against each other")
+	showSummaryTable := flag.Bool("summary-table", false, "print a table of the version count, path count and date range of every domain to stderr at the end of a path mode run")
+	combinedFile := flag.String("combined", "", "in path mode, write the paths of every domain to this single JSON file, keyed by domain, instead of per-domain output")
+	dbFile := flag.String("db", "", "also write paths and timeline changes to this SQLite database")
+	showVersion := flag.Bool("version", false, "print the version, commit and build date and exit")
+	parseFile := flag.String("parse-file", "", "parse a local robots.txt file and print the agents, rules, sitemaps and ignored lines found, without touching the network")
+	stateFile := flag.String("state", "", "state file recording the latest fetched snapshot per domain. Timeline runs only fetch newer snapshots and append to existing output")
 	flag.Parse()
 
-	var urls []string
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		urls = append(urls, scanner.Text())
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+
+	if *parseFile != "" {
+		if err := printParsedFile(*parseFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing %s: %v\n", *parseFile, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	opts := options{
+		versionsLimit:     *versionsLimit,
+		threads:           *threads,
+		timeline:          *timeline,
+		year:              year.from,
+		toYear:            year.to,
+		outputDir:         *outputDir,
+		writeEmpty:        *writeEmpty,
+		envelope:          *envelope,
+		resolver:          *resolver,
+		maxRedirects:      *maxRedirects,
+		maxIdleConns:      *maxIdleConns,
+		timeout:           *timeout,
+		proxy:             *proxy,
+		insecure:          *insecure,
+		maxConnsPerHost:   *maxConnsPerHost,
+		mergeWWW:          *mergeWWW,
+		scheme:            *scheme,
+		withSource:        *withSource,
+		minPathLength:     *minPathLength,
+		stripQuery:        *stripQuery,
+		relative:          *relative,
+		reverse:           *reverse,
+		endpoints:         *endpoints,
+		pathLifespan:      *pathLifespan,
+		ordered:           *ordered,
+		flipped:           *flipped,
+		ruleCounts:        *ruleCounts,
+		rawDiff:           *rawDiff,
+		format:            *format,
+		dotVersion:        *dotVersion,
+		cdxFile:           *cdxFile,
+		cdxPageSize:       *cdxPageSize,
+		latestPerDay:      *latestPerDay,
+		match:             *match,
+		dedupe:            *dedupe,
+		withSnapshot:      *withSnapshot,
+		withSeen:          *withSeen,
+		live:              *live,
+		include:           include,
+		exclude:           exclude,
+		from:              *from,
+		to:                *to,
+		jitter:            *jitter,
+		jitterPerRequest:  *jitterPerRequest,
+		minInterval:       *minInterval,
+		fetchOnly:         *fetchOnly,
+		printSnapshotURLs: *printSnapshots,
+		normalize:         *normalize,
+		netDisallowed:     *netDisallowed,
+		sitemaps:          *sitemaps,
+		expandWildcards:   *expandWildcards,
+	}
+
+	switch opts.scheme {
+	case "", "http", "https", "both":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown -scheme %q, expected http, https or both\n", opts.scheme)
+		os.Exit(1)
+	}
+
+	if *fetchOnly && *cacheDir == "" {
+		fmt.Fprintln(os.Stderr, "-fetch-only requires -cache")
+		os.Exit(1)
+	}
+	if (*noCache || *cacheTTL != 0) && *cacheDir == "" {
+		fmt.Fprintln(os.Stderr, "-no-cache and -cache-ttl require -cache")
+		os.Exit(1)
+	}
+	if opts.cdxPageSize < 0 {
+		fmt.Fprintln(os.Stderr, "-cdx-page-size can't be negative")
+		os.Exit(1)
+	}
+	if *cacheTTL < 0 {
+		fmt.Fprintln(os.Stderr, "-cache-ttl can't be negative")
+		os.Exit(1)
+	}
+	if *cacheDir != "" {
+		if err := os.MkdirAll(*cacheDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating cache directory %s: %v\n", *cacheDir, err)
+			os.Exit(1)
+		}
+		responseCache = &diskCache{dir: *cacheDir, ttl: *cacheTTL, refresh: *noCache}
+	}
+
+	if opts.live && (opts.timeline || opts.netDisallowed) {
+		fmt.Fprintln(os.Stderr, "-live can't be used with -timeline or -net-disallowed")
+		os.Exit(1)
+	}
+	if opts.dedupe && opts.timeline {
+		// Content that changes back would no longer show up as a change
+		fmt.Fprintln(os.Stderr, "-dedupe can't be used with -timeline")
+		os.Exit(1)
+	}
+	switch opts.match {
+	case "exact", "prefix", "host":
+	case "domain":
+		if opts.timeline {
+			// A timeline of several hosts would diff unrelated files
+			fmt.Fprintln(os.Stderr, "-match domain can't be used with -timeline")
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown -match %q, expected exact, prefix, host or domain\n", opts.match)
+		os.Exit(1)
+	}
+	if err := checkDateBound("from", opts.from); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := checkDateBound("to", opts.to); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if opts.from != "" && opts.to != "" && opts.from > opts.to {
+		fmt.Fprintln(os.Stderr, "-from must not be after -to")
+		os.Exit(1)
+	}
+	if *concurrentDomains < 1 {
+		fmt.Fprintf(os.Stderr, "-concurrent must be at least 1, using 1 instead of %d\n", *concurrentDomains)
+		*concurrentDomains = 1
+	}
+	if opts.threads < 1 {
+		fmt.Fprintf(os.Stderr, "-threads must be at least 1, using 1 instead of %d\n", opts.threads)
+		opts.threads = 1
+	}
+	if *retries < 0 {
+		fmt.Fprintln(os.Stderr, "-retries must not be negative")
+		os.Exit(1)
+	}
+	fetchRetries = *retries
+	userAgent = *ua
+
+	zipNameTemplate, err := template.New("zip-name").Option("missingkey=error").Parse(*zipName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -zip-name template: %v\n", err)
+		os.Exit(1)
+	}
+	opts.zipName = zipNameTemplate
+
+	for _, agent := range strings.Split(*flagAgents, ",") {
+		agent = strings.ToLower(strings.TrimSpace(agent))
+		if agent != "" {
+			opts.flagAgents = append(opts.flagAgents, agent)
+		}
+	}
+	for _, agent := range strings.Split(*onlyAgent, ",") {
+		agent = strings.ToLower(strings.TrimSpace(agent))
+		if agent != "" {
+			opts.agents = append(opts.agents, agent)
+		}
+	}
+	if len(opts.agents) > 0 && !opts.timeline {
+		fmt.Fprintln(os.Stderr, "-agent requires -timeline")
+		os.Exit(1)
+	}
+
+	if *subtract != "" {
+		known, err := loadWordlist(*subtract)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading wordlist %s: %v\n", *subtract, err)
+			os.Exit(1)
+		}
+		opts.subtract = known
+	}
+
+	switch opts.format {
+	case "", "plain":
+	case "json", "csv", "ndjson":
+		if opts.timeline {
+			fmt.Fprintf(os.Stderr, "-format %s can't be used with -timeline\n", opts.format)
+			os.Exit(1)
+		}
+	case "dot":
+		if !opts.timeline {
+			fmt.Fprintln(os.Stderr, "-format dot requires -timeline")
+			os.Exit(1)
+		}
+	case "html":
+		if !opts.timeline || opts.outputDir == "" {
+			fmt.Fprintln(os.Stderr, "-format html requires -timeline and -output")
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown -format %q\n", opts.format)
+		os.Exit(1)
+	}
+
+	if *allowOnly && *disallowOnly {
+		fmt.Fprintln(os.Stderr, "-include-allow-only and -include-disallow-only cannot be used together")
+		os.Exit(1)
+	}
+	if *allowOnly {
+		opts.onlyDirective = "allow"
+	} else if *disallowOnly {
+		opts.onlyDirective = "disallow"
+	}
+	switch *directive {
+	case "both":
+	case "allow", "disallow":
+		if opts.onlyDirective != "" && opts.onlyDirective != *directive {
+			fmt.Fprintf(os.Stderr, "-directive %s contradicts -include-%s-only\n", *directive, opts.onlyDirective)
+			os.Exit(1)
+		}
+		opts.onlyDirective = *directive
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown -directive %q, expected allow, disallow or both\n", *directive)
+		os.Exit(1)
 	}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading URLs from stdin: %v\n", err)
+	switch *mode {
+	case "recent", "distributed":
+		opts.recent = *mode == "recent"
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown -mode %q, expected recent or distributed\n", *mode)
 		os.Exit(1)
 	}
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+	if setFlags["recent"] {
+		if setFlags["mode"] && *recent != opts.recent {
+			fmt.Fprintf(os.Stderr, "-recent=%t contradicts -mode %s\n", *recent, *mode)
+			os.Exit(1)
+		}
+		opts.recent = *recent
+	}
+
+	if *stateFile != "" {
+		if !opts.timeline {
+			fmt.Fprintln(os.Stderr, "-state can only be used with -timeline")
+			os.Exit(1)
+		}
+		state, err := loadState(*stateFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading state file %s: %v\n", *stateFile, err)
+			os.Exit(1)
+		}
+		opts.state = state
+	}
+
+	if *showSummaryTable {
+		domainTable = &summaryTable{}
+	}
+
+	if *combinedFile != "" {
+		if opts.timeline || opts.format == "csv" || opts.format == "ndjson" {
+			fmt.Fprintln(os.Stderr, "-combined can't be used with -timeline, -format csv or -format ndjson")
+			os.Exit(1)
+		}
+		combinedPaths = newCombinedOutput()
+	}
+
+	if *dbFile != "" {
+		db, err := openDB(*dbFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening database %s: %v\n", *dbFile, err)
+			os.Exit(1)
+		}
+		resultDB = db
+	}
+
+	if *dumpCDX != "" {
+		f, err := os.OpenFile(*dumpCDX, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening CDX dump file %s: %v\n", *dumpCDX, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		cdxDump = &cdxDumper{w: f}
+	}
+
+	if *verbose || *debug {
+		verboseLog = log.New(os.Stderr, *logPrefix, log.LstdFlags)
+	}
+	if *debug {
+		debugLog = verboseLog
+	}
+
+	if *concurrencyAuto {
+		// The limiter is shared by every domain processed at once
+		fetchLimiter = newAIMDLimiter(*concurrentDomains * opts.threads)
+	}
+	if *cdxWorkers > 0 {
+		cdxSlots = make(chan struct{}, *cdxWorkers)
+	}
+
+	client, err := newHTTPClient(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error configuring HTTP client: %v\n", err)
+		os.Exit(1)
+	}
+	httpClient = client
+
+	var urls []string
+	if *listFile != "" {
+		file, err := os.Open(*listFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening URL list %s: %v\n", *listFile, err)
+			os.Exit(1)
+		}
+		urls, err = readURLs(file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading URLs from %s: %v\n", *listFile, err)
+			os.Exit(1)
+		}
+	}
+	// With -list, stdin is only read when something is piped in
+	if stat, err := os.Stdin.Stat(); *listFile == "" || (err == nil && stat.Mode()&os.ModeCharDevice == 0) {
+		stdinURLs, err := readURLs(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading URLs from stdin: %v\n", err)
+			os.Exit(1)
+		}
+		urls = append(urls, stdinURLs...)
+	}
+
+	if *compare {
+		if len(urls) != 2 {
+			fmt.Fprintf(os.Stderr, "-compare-domains needs exactly two input URLs, got %d\n", len(urls))
+			os.Exit(1)
+		}
+		compareDomains(context.Background(), urls[0], urls[1])
+		return
+	}
+
+	var excluded []string
+	if *excludeDomains != "" {
+		excluded, err = loadExcludePatterns(*excludeDomains)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading excluded domains %s: %v\n", *excludeDomains, err)
+			os.Exit(1)
+		}
+		urls = excludeURLs(urls, excluded)
+	}
+
+	if *dedupeInput {
+		urls = dedupeInputs(urls)
+	}
+	if opts.mergeWWW {
+		urls = dedupeWWW(urls)
+	}
+
+	if *prefilter {
+		urls = prefilterURLs(context.Background(), urls, *concurrentDomains, opts)
+		if *prefilterOut != "" {
+			if err := writeURLList(*prefilterOut, urls); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing filtered list to %s: %v\n", *prefilterOut, err)
+			}
+		}
+	}
+
+	if *findShared {
+		findSharedRobots(context.Background(), urls, *concurrentDomains, opts.outputDir)
+		return
+	}
+
+	output = newOutputWriter()
+	ctx := handleShutdown()
 
 	jobs := make(chan string, len(urls))
 	var wg sync.WaitGroup
@@ -61,7 +626,30 @@ func main() {
 		go func() {
 			defer wg.Done()
 			for rawURL := range jobs {
-				processDomain(rawURL, *versionsLimit, *recent, *timeline, *year, *outputDir)
+				if ctx.Err() != nil {
+					return
+				}
+				source := inputDomain(rawURL)
+				if !*discoverSubdomains {
+					processDomain(ctx, rawURL, source, opts)
+					continue
+				}
+
+				hosts, err := DiscoverSubdomains(ctx, rawURL)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error discovering subdomains for %s: %v\n", rawURL, err)
+					hosts = []string{rawURL} // Fall back to the seed itself
+				}
+				hosts = excludeURLs(hosts, excluded)
+				if opts.mergeWWW {
+					hosts = dedupeWWW(hosts)
+				}
+				for _, host := range hosts {
+					if ctx.Err() != nil {
+						return
+					}
+					processDomain(ctx, host, source, opts)
+				}
 			}
 		}()
 	}
@@ -74,51 +662,88 @@ func main() {
 
 	// Wait for all workers to finish
 	wg.Wait()
+	output.close()
+	resultDB.close()
+	domainTable.render()
+	combinedPaths.write(*combinedFile)
+
+	if opts.outputDir != "" {
+		writeRunSummary(opts.outputDir, started)
+	}
+	if ctx.Err() != nil {
+		os.Exit(130)
+	}
 }
 
-func processDomain(rawURL string, versionsLimit int, recent bool, timeline bool, year int, outputDir string) {
+// processDomain crawls a single target. source is the input domain the target
+// came from, which differs from rawURL for discovered subdomains.
+func processDomain(ctx context.Context, rawURL string, source string, opts options) {
+	stats.domains.Add(1)
+
 	u, err := cleanURL(rawURL)
 	if err != nil {
+		stats.failures.Add(1)
 		fmt.Fprintf(os.Stderr, "Error cleaning URL %s: %v\n", rawURL, err)
 		return
 	}
+	if opts.mergeWWW {
+		u = apexURL(u)
+	}
+	if opts.scheme == "http" || opts.scheme == "https" {
+		u = withScheme(u, opts.scheme)
+	}
 
 	// If output directory and year are specified, check if work has already been done.
-	if outputDir != "" && year > 0 {
+	// Incremental runs are expected to revisit existing output.
+	if opts.outputDir != "" && opts.year > 0 && opts.state == nil {
 		domain := getHost(u)
-		yearStr := strconv.Itoa(year)
-		publisherYearPath := filepath.Join(outputDir, domain, yearStr)
+		yearStr := yearLabel(opts)
+		publisherYearPath := filepath.Join(opts.outputDir, domain, yearStr)
 
 		if _, err := os.Stat(publisherYearPath); !os.IsNotExist(err) {
 			// The directory exists, so we assume the work is done.
-			fmt.Fprintf(os.Stderr, "Output folder for %s/%s already exists, skipping.\n", domain, yearStr)
+			infof("Output folder for %s/%s already exists, skipping.\n", domain, yearStr)
 			return // Skip this domain
 		}
 	}
 
-	if !timeline {
+	if opts.fetchOnly {
+		warmCache(ctx, u, opts)
+		return
+	}
+	if opts.printSnapshotURLs {
+		printSnapshotURLs(ctx, u, opts)
+		return
+	}
+
+	if !opts.timeline {
 		// Original functionality
-		processURL(u, versionsLimit, recent, outputDir)
+		processURL(ctx, u, source, opts)
 	} else {
 		// New timeline functionality
-		createTimeline(u, versionsLimit, recent, year, outputDir)
+		createTimeline(ctx, u, opts)
 	}
 }
 
-func processURL(u string, limit int, recent bool, outputDir string) {
+func processURL(ctx context.Context, u string, source string, opts options) {
 	// Pass 0 for year to use default limit/recent logic
-	versions, err := GetRobotsTxtVersions(u, limit, recent, 0)
+	versions, err := getSnapshots(ctx, u, opts, 0, "")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error getting versions: %v\n", err)
 		return
 	}
+	if len(versions) == 0 {
+		// Unlike a robots.txt without rules, which yields no paths either
+		stats.noRobots.Add(1)
+		infof("No archived robots.txt found for %s\n", u)
+	}
 
-	numThreads := 10
-	jobCh := make(chan string, numThreads)
-	pathCh := make(chan []string)
+	numThreads := opts.threads
+	jobCh := make(chan snapshot, numThreads)
+	pathCh := make(chan []robotsPath)
 
 	progressbarMessage := fmt.Sprintf("Enumerating %s/robots.txt versions...", u)
-	bar := progressbar.Default(int64(len(versions)), progressbarMessage)
+	bar := newProgressBar(int64(len(versions)), progressbarMessage)
 
 	var wg sync.WaitGroup
 	wg.Add(numThreads)
@@ -126,57 +751,267 @@ func processURL(u string, limit int, recent bool, outputDir string) {
 	for i := 0; i < numThreads; i++ {
 		go func() {
 			defer wg.Done()
+			sleepJitter(opts.jitter)
 			for version := range jobCh {
-				GetRobotsTxtPaths(version, u, pathCh, bar)
+				if ctx.Err() != nil {
+					return // Keep the paths collected so far
+				}
+				if opts.jitterPerRequest {
+					sleepJitter(opts.jitter)
+				}
+				fetchPaths(ctx, version, opts.netDisallowed, pathCh, bar)
 			}
 		}()
 	}
 
-	go func() {
-		for _, version := range versions {
-			jobCh <- version
-		}
-		close(jobCh)
-	}()
+	go sendSnapshots(ctx, versions, jobCh)
 
 	go func() {
 		wg.Wait()
 		close(pathCh)
 	}()
 
-	allPaths := make(map[string]bool)
+	allPaths := make(map[string]string) // Key: path, Value: earliest snapshot it was seen in
+	lastSeen := make(map[string]string) // Only filled for -with-seen, Value: latest snapshot
+	sitemaps := make(map[string]bool)   // Only filled for -sitemaps
+	patterns := make(map[string]string) // Only filled for -expand-wildcards
+	rows := make(map[pathRow]string)    // Only filled for -format csv and ndjson, Value: first seen
+	var archived AgentRules             // Only filled for -live
+	if opts.live {
+		archived = make(AgentRules)
+	}
+	var stream *ndjsonWriter
+	if opts.format == "ndjson" {
+		stream = newNDJSONWriter(u, opts)
+	}
+	sightings := make(map[robotsPath]*pathSighting) // Only filled for -db
+	spellings := make(pathSpellings)                // Only filled for -normalize
+
+	// For the summary line of the domain
+	fetched := 0
+	agents := make(map[string]bool)
+	var first, last string
+
 	for pathsBatch := range pathCh {
-		for _, path := range pathsBatch {
-			allPaths[path] = true
+		fetched++
+		seenInBatch := make(map[robotsPath]bool)
+		for _, rp := range pathsBatch {
+			if first == "" || rp.Timestamp < first {
+				first = rp.Timestamp
+			}
+			if rp.Timestamp > last {
+				last = rp.Timestamp
+			}
+			if rp.Agent != "" {
+				agents[rp.Agent] = true
+			}
+			if rp.Directive == "sitemap" {
+				if opts.sitemaps {
+					sitemaps[rp.URL] = true
+				}
+				continue
+			}
+			path := rp.URL
+			if opts.mergeWWW {
+				path = rehostURL(path, u)
+			}
+			if archived != nil {
+				// On the host the live file is fetched from, but otherwise as
+				// archived, before any filter
+				live := rp
+				live.URL = path
+				addArchivedRule(archived, live)
+			}
+			if opts.onlyDirective != "" && rp.Directive != opts.onlyDirective {
+				continue
+			}
+			if opts.stripQuery {
+				path = removeQuery(path)
+			}
+			if opts.normalize {
+				path = spellings.representative(normalizeEncoding(path))
+			}
+			if opts.expandWildcards {
+				if expanded := expandWildcard(path); expanded != path {
+					patterns[path] = expanded
+					path = expanded
+				}
+			}
+			if opts.minPathLength > 0 && pathLength(path) < opts.minPathLength {
+				continue
+			}
+			if opts.subtract != nil && opts.subtract[wordlistKey(path)] {
+				continue
+			}
+			if !matchesPathFilters(path, opts.include, opts.exclude) {
+				continue
+			}
+			if opts.relative {
+				path = relativePath(path)
+			}
+			if seen, ok := allPaths[path]; !ok || rp.Timestamp < seen {
+				allPaths[path] = rp.Timestamp
+			}
+			if opts.withSeen && rp.Timestamp > lastSeen[path] {
+				lastSeen[path] = rp.Timestamp
+			}
+			if opts.format == "csv" || stream != nil {
+				row := pathRow{path: path, directive: rp.Directive, agent: rp.Agent}
+				seen, ok := rows[row]
+				if !ok && stream != nil {
+					stream.write(row)
+				}
+				if !ok || rp.Timestamp < seen {
+					rows[row] = rp.Timestamp
+				}
+			}
+
+			// Each batch is one snapshot, count it once per path
+			key := robotsPath{URL: path, Directive: rp.Directive}
+			if resultDB == nil || seenInBatch[key] {
+				continue
+			}
+			seenInBatch[key] = true
+			s, ok := sightings[key]
+			if !ok {
+				s = &pathSighting{firstSeen: rp.Timestamp, lastSeen: rp.Timestamp}
+				sightings[key] = s
+			}
+			if rp.Timestamp < s.firstSeen {
+				s.firstSeen = rp.Timestamp
+			}
+			if rp.Timestamp > s.lastSeen {
+				s.lastSeen = rp.Timestamp
+			}
+			s.snapshots++
 		}
 	}
+	resultDB.writePaths(getHost(u), sightings)
 
-	if outputDir != "" {
-		writePathsJSON(u, allPaths, outputDir)
-	} else {
-		for path := range allPaths {
-			fmt.Println(path)
+	stats.paths.Add(int64(len(allPaths)))
+	verbosef("Found %d unique paths in %d versions of %s", len(allPaths), len(versions), u)
+	if len(versions) > 0 {
+		infof("%s: %d snapshots fetched, %d unique paths, %d user-agents, %s to %s\n", u, fetched, len(allPaths), len(agents), formatDay(first), formatDay(last))
+	}
+	domainTable.add(getHost(u), versions, len(allPaths))
+
+	if archived != nil {
+		live, err := fetchLiveRules(ctx, u)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching live robots.txt of %s: %v\n", u, err)
+		} else {
+			changes := diffLive(live, archived)
+			output.submit(func() { writeLiveDiff(u, changes, opts) })
+		}
+		if opts.outputDir == "" {
+			return // The diff replaces the path list on stdout
 		}
 	}
+
+	if opts.outputDir != "" {
+		output.submit(func() {
+			if opts.sitemaps {
+				writeSitemapsJSON(u, sitemaps, opts)
+			}
+			writeWildcardPatterns(u, patterns, opts)
+		})
+	}
+	if combinedPaths != nil {
+		combinedPaths.add(getHost(u), allPaths)
+	} else if stream != nil {
+		output.submit(stream.close)
+	} else if opts.format == "csv" {
+		output.submit(func() { writePathsCSV(u, source, rows, opts) })
+	} else if opts.outputDir != "" || opts.format == "json" {
+		output.submit(func() { writePathsJSON(u, allPaths, lastSeen, len(versions), opts) })
+	} else {
+		output.submit(func() {
+			for path, timestamp := range allPaths {
+				line := path
+				if opts.withSnapshot {
+					line += "\t" + replayURL(timestamp, absolutePath(u, path, opts))
+				}
+				if opts.withSource {
+					fmt.Printf("%s\t%s\n", source, line)
+				} else {
+					fmt.Println(line)
+				}
+			}
+			// Sitemaps are printed as extra URLs after the paths
+			for sitemap := range sitemaps {
+				if opts.withSource {
+					fmt.Printf("%s\t%s\n", source, sitemap)
+				} else {
+					fmt.Println(sitemap)
+				}
+			}
+		})
+	}
 }
 
-func createTimeline(u string, limit int, recent bool, year int, outputDir string) {
-	versions, err := GetRobotsTxtVersions(u, limit, recent, year)
+// printSnapshotURLs lists the archive URLs of the snapshots that would be
+// fetched for u, without fetching them.
+func printSnapshotURLs(ctx context.Context, u string, opts options) {
+	year := 0
+	if opts.timeline {
+		year = opts.year
+	}
+	versions, err := getSnapshots(ctx, u, opts, year, "")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error getting versions: %v\n", err)
 		return
 	}
-	if len(versions) == 0 {
-		fmt.Fprintf(os.Stderr, "No versions found for %s (Year: %d)\n", u, year)
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Timestamp < versions[j].Timestamp
+	})
+
+	urls := make([]string, 0, len(versions))
+	for _, version := range versions {
+		urls = append(urls, waybackrobots.SnapshotURL(version.Timestamp, version.URL, version.Original))
+	}
+
+	if opts.outputDir == "" {
+		output.submit(func() {
+			for _, line := range urls {
+				fmt.Println(line)
+			}
+		})
+		return
+	}
+
+	output.submit(func() {
+		dirPath := filepath.Join(opts.outputDir, getHost(u))
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", dirPath, err)
+			return
+		}
+		filePath := filepath.Join(dirPath, "snapshot_urls.txt")
+		if err := writeURLList(filePath, urls); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing snapshot URLs to %s: %v\n", filePath, err)
+		} else {
+			infof("Wrote %d snapshot URLs to %s\n", len(urls), filePath)
+		}
+	})
+}
+
+// warmCache fetches the CDX listing and every selected snapshot of u into the
+// cache without parsing anything.
+func warmCache(ctx context.Context, u string, opts options) {
+	year := 0
+	if opts.timeline {
+		year = opts.year
+	}
+	versions, err := getSnapshots(ctx, u, opts, year, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting versions: %v\n", err)
 		return
 	}
 
-	numThreads := 10
-	jobCh := make(chan string, numThreads)
-	resultCh := make(chan VersionContent, len(versions))
+	numThreads := opts.threads
+	jobCh := make(chan snapshot, numThreads)
 
-	progressbarMessage := fmt.Sprintf("Fetching %s/robots.txt versions for timeline...", u)
-	bar := progressbar.Default(int64(len(versions)), progressbarMessage)
+	progressbarMessage := fmt.Sprintf("Caching %s/robots.txt versions...", u)
+	bar := newProgressBar(int64(len(versions)), progressbarMessage)
 
 	var wg sync.WaitGroup
 	wg.Add(numThreads)
@@ -184,207 +1019,162 @@ func createTimeline(u string, limit int, recent bool, year int, outputDir string
 	for i := 0; i < numThreads; i++ {
 		go func() {
 			defer wg.Done()
+			sleepJitter(opts.jitter)
 			for version := range jobCh {
-				rules, rawContent := GetRobotsTxtPathsForTimeline(version, u, bar)
-				resultCh <- VersionContent{Timestamp: version, Rules: rules, RawContent: rawContent}
+				if ctx.Err() != nil {
+					return
+				}
+				if opts.jitterPerRequest {
+					sleepJitter(opts.jitter)
+				}
+				if _, err := fetch(ctx, waybackrobots.SnapshotURL(version.Timestamp, version.URL, version.Original)); err == nil {
+					stats.snapshots.Add(1)
+				}
+				bar.Add(1)
 			}
 		}()
 	}
 
-	for _, version := range versions {
-		jobCh <- version
-	}
-	close(jobCh)
-
+	sendSnapshots(ctx, versions, jobCh)
 	wg.Wait()
-	close(resultCh)
-
-	// Sort versions by timestamp
-	versionContents := make([]VersionContent, 0, len(versions))
-	for vc := range resultCh {
-		versionContents = append(versionContents, vc)
-	}
-	sort.Slice(versionContents, func(i, j int) bool {
-		return versionContents[i].Timestamp < versionContents[j].Timestamp
-	})
+}
 
-	if outputDir != "" {
-		writeTimelineOutput(u, versionContents, year, outputDir)
-		return
+// flaggedAgents returns the agents on the watchlist that gained rules in
+// current compared to previous. Every watched agent of the initial version
+// (previous is nil) counts as having gained rules.
+func flaggedAgents(current, previous AgentRules, watchlist []string) []string {
+	if len(watchlist) == 0 {
+		return nil
 	}
 
-	// Compare versions and print timeline to STDOUT
-	var previousRules AgentRules
-	for _, vc := range versionContents {
-		addedAgents := []string{}
-		removedAgents := []string{}
-		ruleChanges := false
-
-		// Find added/changed agents
-		for agent, currentRules := range vc.Rules {
-			prevAgentRules, exists := previousRules[agent]
-			if !exists {
-				addedAgents = append(addedAgents, agent)
-				ruleChanges = true
-				continue
-			}
-
-			// Check for path changes within the agent
-			addedAllows, removedAllows, addedDisallows, removedDisallows := diffRuleSets(currentRules, prevAgentRules)
-			if len(addedAllows) > 0 || len(removedAllows) > 0 || len(addedDisallows) > 0 || len(removedDisallows) > 0 {
-				ruleChanges = true
-			}
+	var flagged []string
+	for agent, rules := range current {
+		if !matchesWatchlist(agent, watchlist) {
+			continue
 		}
-
-		// Find removed agents
-		for agent := range previousRules {
-			if _, exists := vc.Rules[agent]; !exists {
-				removedAgents = append(removedAgents, agent)
-				ruleChanges = true
-			}
+		prevAgentRules, exists := previous[agent]
+		if !exists {
+			flagged = append(flagged, agent)
+			continue
 		}
-
-		if !ruleChanges && len(addedAgents) == 0 && len(removedAgents) == 0 && previousRules != nil {
-			continue // Skip if no changes *and* it's not the first version
+		addedAllows, _, addedDisallows, _ := waybackrobots.DiffRuleSets(rules, prevAgentRules)
+		if len(addedAllows) > 0 || len(addedDisallows) > 0 {
+			flagged = append(flagged, agent)
 		}
+	}
+	sort.Strings(flagged)
+	return flagged
+}
 
-		fmt.Printf("\n--- Changes on %s ---\n", vc.Timestamp)
+// matchesWatchlist reports whether agent contains any of the lowercased
+// watchlist names, ignoring case.
+func matchesWatchlist(agent string, watchlist []string) bool {
+	agent = strings.ToLower(agent)
+	for _, name := range watchlist {
+		if strings.Contains(agent, name) {
+			return true
+		}
+	}
+	return false
+}
 
-		if previousRules == nil {
-			fmt.Println("Initial version:")
-			for agent, rules := range vc.Rules {
-				fmt.Printf("  User-agent: %s\n", agent)
-				allows := []string{}
-				disallows := []string{}
-				for path, directive := range rules {
-					if directive == "allow" {
-						allows = append(allows, path)
-					} else {
-						disallows = append(disallows, path)
-					}
-				}
-				sort.Strings(allows)
-				sort.Strings(disallows)
+// delayChange is a Crawl-delay that was set, changed or dropped. From is
+// empty for a new delay and To for a removed one.
+type delayChange struct {
+	UserAgent string `json:"user_agent"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+}
 
-				if len(allows) > 0 {
-					fmt.Println("    Allow:")
-					for _, path := range allows {
-						fmt.Printf("      + %s\n", path)
-					}
-				}
-				if len(disallows) > 0 {
-					fmt.Println("    Disallow:")
-					for _, path := range disallows {
-						fmt.Printf("      + %s\n", path)
-					}
-				}
-			}
-		} else {
-			for _, agent := range addedAgents {
-				fmt.Printf("  [+] New User-agent: %s\n", agent)
-				// Similar logic as initial version to print all rules for the new agent
-				rules := vc.Rules[agent]
-				allows := []string{}
-				disallows := []string{}
-				for path, directive := range rules {
-					if directive == "allow" {
-						allows = append(allows, path)
-					} else {
-						disallows = append(disallows, path)
-					}
-				}
-				sort.Strings(allows)
-				sort.Strings(disallows)
-				if len(allows) > 0 {
-					fmt.Println("    Allow:")
-					for _, path := range allows {
-						fmt.Printf("      + %s\n", path)
-					}
-				}
-				if len(disallows) > 0 {
-					fmt.Println("    Disallow:")
-					for _, path := range disallows {
-						fmt.Printf("      + %s\n", path)
-					}
-				}
-			}
-			for _, agent := range removedAgents {
-				fmt.Printf("  [-] Removed User-agent: %s\n", agent)
-			}
-
-			for agent, currentRules := range vc.Rules {
-				if prevAgentRules, exists := previousRules[agent]; exists {
-					addedAllows, removedAllows, addedDisallows, removedDisallows := diffRuleSets(currentRules, prevAgentRules)
-
-					if len(addedAllows) > 0 || len(removedAllows) > 0 || len(addedDisallows) > 0 || len(removedDisallows) > 0 {
-						fmt.Printf("  [~] Changed User-agent: %s\n", agent)
-						if len(addedAllows) > 0 || len(removedAllows) > 0 {
-							fmt.Println("    Allow:")
-							for _, path := range addedAllows {
-								fmt.Printf("      + %s\n", path)
-							}
-							for _, path := range removedAllows {
-								fmt.Printf("      - %s\n", path)
-							}
-						}
-						if len(addedDisallows) > 0 || len(removedDisallows) > 0 {
-							fmt.Println("    Disallow:")
-							for _, path := range addedDisallows {
-								fmt.Printf("      + %s\n", path)
-							}
-							for _, path := range removedDisallows {
-								fmt.Printf("      - %s\n", path)
-							}
-						}
-					}
-				}
-			}
-		}
-		previousRules = vc.Rules
+// String formats the change as "30 -> 10", with "none" for a missing delay.
+func (c delayChange) String() string {
+	from, to := c.From, c.To
+	if from == "" {
+		from = "none"
+	}
+	if to == "" {
+		to = "none"
 	}
+	return from + " -> " + to
 }
 
-func diffRuleSets(current, previous RuleSet) (addedAllows, removedAllows, addedDisallows, removedDisallows []string) {
-	for path, directive := range current {
-		prevDirective, exists := previous[path]
-		if !exists { // Path is new
-			if directive == "allow" {
-				addedAllows = append(addedAllows, path)
-			} else {
-				addedDisallows = append(addedDisallows, path)
-			}
-		} else if directive != prevDirective { // Path changed directive
-			if directive == "allow" { // Was disallow, now allow
-				addedAllows = append(addedAllows, path)
-				removedDisallows = append(removedDisallows, path)
-			} else { // Was allow, now disallow
-				addedDisallows = append(addedDisallows, path)
-				removedAllows = append(removedAllows, path)
-			}
+// diffDelays lists the agents whose Crawl-delay differs between two versions,
+// sorted by agent. Unchanged delays are left out.
+func diffDelays(current, previous AgentDelays) []delayChange {
+	var changes []delayChange
+	for agent, delay := range current {
+		if previous[agent] != delay {
+			changes = append(changes, delayChange{UserAgent: agent, From: previous[agent], To: delay})
+		}
+	}
+	for agent, delay := range previous {
+		if _, exists := current[agent]; !exists {
+			changes = append(changes, delayChange{UserAgent: agent, From: delay})
 		}
 	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].UserAgent < changes[j].UserAgent
+	})
+	return changes
+}
 
-	for path, prevDirective := range previous {
-		if _, exists := current[path]; !exists { // Path was removed
-			if prevDirective == "allow" {
-				removedAllows = append(removedAllows, path)
-			} else {
-				removedDisallows = append(removedDisallows, path)
-			}
+// diffSitemaps returns the sitemaps only in current and only in previous, both
+// sorted lists.
+func diffSitemaps(current, previous []string) (added, removed []string) {
+	i, j := 0, 0
+	for i < len(current) || j < len(previous) {
+		switch {
+		case j == len(previous) || (i < len(current) && current[i] < previous[j]):
+			added = append(added, current[i])
+			i++
+		case i == len(current) || previous[j] < current[i]:
+			removed = append(removed, previous[j])
+			j++
+		default:
+			i++
+			j++
 		}
 	}
-	sort.Strings(addedAllows)
-	sort.Strings(removedAllows)
-	sort.Strings(addedDisallows)
-	sort.Strings(removedDisallows)
-	return
+	return added, removed
+}
+
+// pathsEnvelope makes paths.json self-describing when -envelope is set.
+type pathsEnvelope struct {
+	Domain       string            `json:"domain"`
+	ScannedAt    string            `json:"scanned_at"`
+	Settings     map[string]string `json:"settings"`
+	VersionCount int               `json:"version_count"`
+	Paths        interface{}       `json:"paths"`
+}
+
+// snapshotPath is a paths.json entry with -with-snapshot.
+type snapshotPath struct {
+	Path     string `json:"path"`
+	Snapshot string `json:"snapshot"` // Replay link of the path as of the snapshot it was found in
 }
 
-func writePathsJSON(u string, paths map[string]bool, outputDir string) {
+// seenPath is a paths.json entry with -with-seen. Timestamps are those of the
+// snapshots, as YYYYMMDDhhmmss.
+type seenPath struct {
+	URL       string `json:"url"`
+	FirstSeen string `json:"first_seen"`
+	LastSeen  string `json:"last_seen"`
+	Snapshot  string `json:"snapshot,omitempty"` // With -with-snapshot
+}
+
+// replayURL links to the archived page of rawURL at timestamp, or the closest
+// capture the archive has of it.
+func replayURL(timestamp, rawURL string) string {
+	return fmt.Sprintf("https://web.archive.org/web/%s/%s", timestamp, rawURL)
+}
+
+// writePathsJSON writes the paths of u to paths.json, or prints them with
+// -format json. paths maps every path to the first snapshot it was found in,
+// and lastSeen, with -with-seen, to the last one.
+func writePathsJSON(u string, paths map[string]string, lastSeen map[string]string, versionCount int, opts options) {
 	domain := getHost(u)
-	dirPath := filepath.Join(outputDir, domain)
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", dirPath, err)
+	if len(paths) == 0 && !opts.writeEmpty {
+		infof("No paths found for %s\n", domain)
 		return
 	}
 
@@ -394,429 +1184,575 @@ func writePathsJSON(u string, paths map[string]bool, outputDir string) {
 	}
 	sort.Strings(pathList)
 
-	filePath := filepath.Join(dirPath, "paths.json")
-	file, err := os.Create(filePath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating file %s: %v\n", filePath, err)
+	// The bare array stays the default for compatibility
+	var entries interface{} = pathList
+	if opts.withSnapshot {
+		withSnapshots := make([]snapshotPath, 0, len(pathList))
+		for _, path := range pathList {
+			withSnapshots = append(withSnapshots, snapshotPath{Path: path, Snapshot: replayURL(paths[path], absolutePath(u, path, opts))})
+		}
+		entries = withSnapshots
+	}
+	if opts.withSeen {
+		withSeen := make([]seenPath, 0, len(pathList))
+		for _, path := range pathList {
+			entry := seenPath{URL: path, FirstSeen: paths[path], LastSeen: lastSeen[path]}
+			if opts.withSnapshot {
+				entry.Snapshot = replayURL(paths[path], absolutePath(u, path, opts))
+			}
+			withSeen = append(withSeen, entry)
+		}
+		entries = withSeen
+	}
+	content := entries
+	if opts.envelope {
+		content = pathsEnvelope{
+			Domain:       domain,
+			ScannedAt:    time.Now().UTC().Format(time.RFC3339),
+			Settings:     flagSettings(),
+			VersionCount: versionCount,
+			Paths:        entries,
+		}
+	}
+
+	if opts.outputDir == "" {
+		// -format json without -output
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(content); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing JSON for %s: %v\n", u, err)
+		}
+		return
+	}
+
+	dirPath := filepath.Join(opts.outputDir, domain)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", dirPath, err)
 		return
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(pathList); err != nil {
+	filePath := filepath.Join(dirPath, "paths.json")
+	if err := writeJSONFile(filePath, content); err != nil {
 		fmt.Fprintf(os.Stderr, "Error writing JSON to %s: %v\n", filePath, err)
 	} else {
-		fmt.Fprintf(os.Stderr, "Wrote paths to %s\n", filePath)
+		infof("Wrote paths to %s\n", filePath)
 	}
 }
 
-// writeTimelineOutput handles writing both the JSON delta file and the raw
-// robots.txt files for the specified year.
-func writeTimelineOutput(u string, versionContents []VersionContent, year int, outputDir string) {
-	if len(versionContents) == 0 {
-		fmt.Fprintf(os.Stderr, "No versions to write for %s\n", u)
-		return
-	}
-
+// writeSitemapsJSON writes the Sitemap URLs found across all versions of u to
+// sitemaps.json next to paths.json.
+func writeSitemapsJSON(u string, sitemaps map[string]bool, opts options) {
 	domain := getHost(u)
-	var dirPath string
-	var jsonFileName string
-
-	if year > 0 {
-		dirPath = filepath.Join(outputDir, domain, strconv.Itoa(year))
-		jsonFileName = fmt.Sprintf("timeline_%d.json", year)
-	} else {
-		dirPath = filepath.Join(outputDir, domain)
-		jsonFileName = "timeline.json"
+	if len(sitemaps) == 0 && !opts.writeEmpty {
+		infof("No sitemaps found for %s\n", domain)
+		return
 	}
 
+	dirPath := filepath.Join(opts.outputDir, domain)
 	if err := os.MkdirAll(dirPath, 0755); err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", dirPath, err)
 		return
 	}
 
-	// --- Structs for JSON output ---
-	type changeSet struct {
-		Added   []string `json:"added,omitempty"`
-		Removed []string `json:"removed,omitempty"`
-	}
-	type ruleChange struct {
-		UserAgent string    `json:"user_agent"`
-		Allow     changeSet `json:"allow,omitempty"`
-		Disallow  changeSet `json:"disallow,omitempty"`
-	}
-	type timelineEntry struct {
-		Timestamp      string       `json:"timestamp"`
-		AgentsAdded    []string     `json:"agents_added,omitempty"`
-		AgentsRemoved  []string     `json:"agents_removed,omitempty"`
-		RuleChanges    []ruleChange `json:"rule_changes,omitempty"`
-		InitialContent []ruleChange `json:"initial_content,omitempty"`
-	}
-
-	var timeline []timelineEntry
-	var previousRules AgentRules
-	filesToZip := make(map[string]string) // K: filename, V: content
-
-	// --- Process versions to find changes and collect files to zip ---
-	for _, vc := range versionContents {
-		entry := timelineEntry{Timestamp: vc.Timestamp}
-		isMeaningfulChange := false
-
-		if previousRules == nil {
-			// --- Initial version (for JSON) ---
-			if vc.Rules != nil && len(vc.Rules) > 0 {
-				isMeaningfulChange = true // The first entry is a change if it has content
-				for agent, rules := range vc.Rules {
-					allows := []string{}
-					disallows := []string{}
-					for path, directive := range rules {
-						if directive == "allow" {
-							allows = append(allows, path)
-						} else {
-							disallows = append(disallows, path)
-						}
-					}
-					sort.Strings(allows)
-					sort.Strings(disallows)
-					change := ruleChange{UserAgent: agent}
-					if len(allows) > 0 {
-						change.Allow.Added = allows
-					}
-					if len(disallows) > 0 {
-						change.Disallow.Added = disallows
-					}
-					entry.InitialContent = append(entry.InitialContent, change)
-				}
-			}
-		} else {
-			// --- Compare with previous version (for JSON and raw file logic) ---
-			// Find added agents
-			for agent, rules := range vc.Rules {
-				if _, exists := previousRules[agent]; !exists {
-					entry.AgentsAdded = append(entry.AgentsAdded, agent)
-					// also list the initial rules for the new agent
-					allows := []string{}
-					disallows := []string{}
-					for path, directive := range rules {
-						if directive == "allow" {
-							allows = append(allows, path)
-						} else {
-							disallows = append(disallows, path)
-						}
-					}
-					sort.Strings(allows)
-					sort.Strings(disallows)
-					change := ruleChange{UserAgent: agent}
-					if len(allows) > 0 {
-						change.Allow.Added = allows
-					}
-					if len(disallows) > 0 {
-						change.Disallow.Added = disallows
-					}
-					entry.RuleChanges = append(entry.RuleChanges, change)
-					isMeaningfulChange = true
-				}
-			}
-			sort.Strings(entry.AgentsAdded)
-
-			// Find removed agents
-			for agent := range previousRules {
-				if _, exists := vc.Rules[agent]; !exists {
-					entry.AgentsRemoved = append(entry.AgentsRemoved, agent)
-					isMeaningfulChange = true
-				}
-			}
-			sort.Strings(entry.AgentsRemoved)
-
-			// Find rule changes for existing agents
-			for agent, currentRules := range vc.Rules {
-				if prevAgentRules, exists := previousRules[agent]; exists {
-					addedAllows, removedAllows, addedDisallows, removedDisallows := diffRuleSets(currentRules, prevAgentRules)
+	sitemapList := make([]string, 0, len(sitemaps))
+	for sitemap := range sitemaps {
+		sitemapList = append(sitemapList, sitemap)
+	}
+	sort.Strings(sitemapList)
 
-					if len(addedAllows) > 0 || len(removedAllows) > 0 || len(addedDisallows) > 0 || len(removedDisallows) > 0 {
-						change := ruleChange{UserAgent: agent}
-						change.Allow = changeSet{Added: addedAllows, Removed: removedAllows}
-						change.Disallow = changeSet{Added: addedDisallows, Removed: removedDisallows}
-						entry.RuleChanges = append(entry.RuleChanges, change)
-						isMeaningfulChange = true
-					}
-				}
-			}
-		}
+	filePath := filepath.Join(dirPath, "sitemaps.json")
+	if err := writeJSONFile(filePath, sitemapList); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing JSON to %s: %v\n", filePath, err)
+	} else {
+		infof("Wrote sitemaps to %s\n", filePath)
+	}
+}
 
-		// --- Collect raw .txt file content if this is the first one or if there are changes ---
-		if isMeaningfulChange && vc.RawContent != "" {
-			if year > 0 {
-				// If year is specified, add to zip map instead of writing directly
-				fileName := fmt.Sprintf("robots_%s.txt", vc.Timestamp)
-				filesToZip[fileName] = vc.RawContent
-			} else {
-				// Original behavior: write individual files if not using -year
-				rawFileName := fmt.Sprintf("robots_%s.txt", vc.Timestamp)
-				rawFilePath := filepath.Join(dirPath, rawFileName)
-				err := ioutil.WriteFile(rawFilePath, []byte(vc.RawContent), 0644)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error writing raw file %s: %v\n", rawFilePath, err)
-				}
+// getSnapshots lists the selected captures for u, and for its www sibling
+// when -merge-www is set.
+func getSnapshots(ctx context.Context, u string, opts options, year int, since string) ([]snapshot, error) {
+	toYear := 0
+	if year > 0 {
+		toYear = opts.toYear
+	}
+	targets := []string{u}
+	if opts.mergeWWW {
+		targets = append(targets, wwwURL(u))
+	}
+	bases := make(map[string]string) // Key: target, Value: base URL of its paths
+	for _, target := range targets {
+		bases[target] = target
+		if opts.scheme == "both" {
+			// The other scheme's captures are fetched as archived but their
+			// paths are merged under the input's scheme
+			other := withScheme(target, "http")
+			if strings.HasPrefix(target, "http://") {
+				other = withScheme(target, "https")
 			}
+			targets = append(targets, other)
+			bases[other] = target
 		}
-
-		if isMeaningfulChange {
-			timeline = append(timeline, entry)
-		}
-		previousRules = vc.Rules
 	}
 
-	// --- Write the collected .txt files to a zip archive if year is specified ---
-	if year > 0 && len(filesToZip) > 0 {
-		zipFileName := fmt.Sprintf("robots_txt_%d.zip", year)
-		zipFilePath := filepath.Join(dirPath, zipFileName)
-		zipFile, err := os.Create(zipFilePath)
+	snapshots := make([]snapshot, 0)
+	seen := make(map[snapshot]bool) // CDX may list a capture under either scheme
+	for _, target := range targets {
+		if opts.cdxFile != "" {
+			verbosef("Reading the CDX listing of %s from %s", target, opts.cdxFile)
+		}
+		captures, err := archive.GetRobotsTxtCaptures(ctx, target, VersionQuery{
+			Limit:        opts.versionsLimit,
+			Recent:       opts.recent,
+			Year:         year,
+			ToYear:       toYear,
+			Since:        since,
+			Endpoints:    opts.endpoints,
+			CDXFile:      opts.cdxFile,
+			LatestPerDay: opts.latestPerDay,
+			From:         opts.from,
+			To:           opts.to,
+			MatchType:    opts.match,
+			DedupeDigest: opts.dedupe,
+			PageSize:     opts.cdxPageSize,
+		})
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating zip file %s: %v\n", zipFilePath, err)
-			return
+			return nil, err
 		}
-		defer zipFile.Close()
-
-		zipWriter := zip.NewWriter(zipFile)
-		defer zipWriter.Close()
-
-		for name, content := range filesToZip {
-			f, err := zipWriter.Create(name)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error adding file %s to zip: %v\n", name, err)
-				continue
+		verbosef("Found %d versions of %s", len(captures), target)
+		for _, capture := range captures {
+			base := bases[target]
+			if opts.match == "host" || opts.match == "domain" {
+				// Paths belong to the host the capture was made of
+				base = captureBaseURL(base, capture.Original)
+			}
+			original := capture.Original
+			if original == "" && bases[target] != target {
+				original = target + "/robots.txt" // Not where the base URL points
 			}
-			_, err = f.Write([]byte(content))
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing content for file %s to zip: %v\n", name, err)
+			s := snapshot{Timestamp: capture.Timestamp, URL: base, Original: original}
+			if seen[s] {
 				continue
 			}
+			seen[s] = true
+			snapshots = append(snapshots, s)
 		}
-		fmt.Fprintf(os.Stderr, "Wrote %d txt files to %s\n", len(filesToZip), zipFilePath)
 	}
+	return snapshots, nil
+}
 
-	// --- Write the JSON timeline.json file ---
-	// Only write the file if there's something to write
-	if len(timeline) > 0 {
-		jsonFilePath := filepath.Join(dirPath, jsonFileName)
-		file, err := os.Create(jsonFilePath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating file %s: %v\n", jsonFilePath, err)
-			return
-		}
-		defer file.Close()
-
-		encoder := json.NewEncoder(file)
-		encoder.SetIndent("", "  ")
-		if err := encoder.Encode(timeline); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing JSON to %s: %v\n", jsonFilePath, err)
-		} else {
-			fmt.Fprintf(os.Stderr, "Wrote timeline to %s\n", jsonFilePath)
-		}
-	} else {
-		fmt.Fprintf(os.Stderr, "No meaningful changes found for %s in %d. No timeline file written.\n", u, year)
+// captureBaseURL returns the base URL of the host original was captured
+// under, with the scheme of target. Ports are dropped, as CDX lists captures of
+// the same file under :80 and :443 too. It falls back to target.
+func captureBaseURL(target, original string) string {
+	parsed, err := url.Parse(original)
+	if err != nil || parsed.Hostname() == "" {
+		return target
+	}
+	scheme := "https"
+	if i := strings.Index(target, "://"); i >= 0 {
+		scheme = target[:i]
 	}
+	return scheme + "://" + strings.ToLower(parsed.Hostname())
 }
 
-func GetRobotsTxtVersions(url string, limit int, recent bool, year int) ([]string, error) {
-	var requestURL string
+// timelineDir returns the directory timeline output for u is written to.
+func timelineDir(u string, opts options) string {
+	if opts.year > 0 {
+		return filepath.Join(opts.outputDir, getHost(u), yearLabel(opts))
+	}
+	return filepath.Join(opts.outputDir, getHost(u))
+}
 
-	if year > 0 {
-		// Year is specified, override limit/recent and use from/to
-		from := fmt.Sprintf("%d0101000000", year)
-		to := fmt.Sprintf("%d1231235959", year)
-		requestURL = fmt.Sprintf("https://web.archive.org/cdx/search/cdx?url=%s/robots.txt&output=json&fl=timestamp&filter=statuscode:200&collapse=digest&from=%s&to=%s", url, from, to)
-	} else {
-		// No year, use original logic
-		requestURL = fmt.Sprintf("https://web.archive.org/cdx/search/cdx?url=%s/robots.txt&output=json&fl=timestamp&filter=statuscode:200&collapse=digest", url)
-		if limit != -1 && recent {
-			requestURL += "&limit=-" + strconv.Itoa(limit)
-		}
+// checkDateBound validates a -from or -to value, which is either empty, a
+// date (YYYYMMDD) or a full CDX timestamp (YYYYMMDDhhmmss).
+func checkDateBound(name, value string) error {
+	if value == "" {
+		return nil
+	}
+	layout := "20060102150405"
+	if len(value) == 8 {
+		layout = "20060102"
+	}
+	if _, err := time.Parse(layout, value); err != nil || (len(value) != 8 && len(value) != 14) {
+		return fmt.Errorf("invalid -%s %q, expected YYYYMMDD or YYYYMMDDhhmmss", name, value)
 	}
+	return nil
+}
 
-	res, err := http.Get(requestURL)
+// DiscoverSubdomains queries CDX for every host under the seed's domain that
+// has a captured robots.txt and returns them as base URLs.
+func DiscoverSubdomains(ctx context.Context, rawURL string) ([]string, error) {
+	u, err := cleanURL(rawURL)
 	if err != nil {
 		return nil, err
 	}
+	domain := strings.TrimPrefix(getHost(u), "www.")
+	scope := waybackrobots.CDXScope("https://"+domain, "domain")
+	requestURL := fmt.Sprintf("https://web.archive.org/cdx/search/cdx?%s&output=json&fl=original&filter=statuscode:200&collapse=urlkey", scope)
 
-	raw, err := ioutil.ReadAll(res.Body)
-	res.Body.Close()
+	raw, err := fetchCDX(ctx, requestURL)
 	if err != nil {
 		return nil, err
 	}
+	cdxDump.dump(requestURL, raw)
 
-	var versions [][]string
-	err = json.Unmarshal(raw, &versions)
+	var rows [][]string
+	err = json.Unmarshal(raw, &rows)
 	if err != nil {
 		return nil, err
 	}
-	if len(versions) == 0 {
-		return []string{}, nil
+	if len(rows) == 0 {
+		return []string{u}, nil
 	}
 
-	versions = versions[1:] // Skip header row
+	rows = rows[1:] // Skip header row
+
+	// Captures of one host under several ports or schemes are a single host
+	seen := make(map[string]bool)
+	hosts := make([]string, 0)
+	for _, row := range rows {
+		if len(row) == 0 {
+			continue
+		}
+		original := row[0]
+		if !strings.Contains(original, "://") {
+			original = "http://" + original
+		}
+		parsed, err := url.Parse(original)
+		if err != nil || parsed.Hostname() == "" {
+			continue
+		}
+		host := captureBaseURL(u, original)
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
 
-	selectedVersions := make([]string, 0)
-	length := len(versions)
+	infof("Discovered %d hosts with robots.txt under %s\n", len(hosts), domain)
+	return hosts, nil
+}
 
-	if year > 0 {
-		// If year was specified, we want all versions returned
-		for _, version := range versions {
-			selectedVersions = append(selectedVersions, version...)
+// archive is the waybackrobots client behind every fetch, going through the
+// cache, retries and rate limiting of fetch.
+var archive = &waybackrobots.Client{
+	Fetch: fetch,
+	FetchCDX: func(ctx context.Context, requestURL string) ([]byte, error) {
+		raw, err := fetchCDX(ctx, requestURL)
+		if err == nil {
+			cdxDump.dump(requestURL, raw)
 		}
-	} else {
-		// Use original limit/recent logic if no year was given
-		if recent || limit == -1 || length <= limit {
-			for _, version := range versions {
-				selectedVersions = append(selectedVersions, version...)
-			}
-		} else {
-			interval := float64(length) / float64(limit-1)
-			for i := 0; i < limit; i++ {
-				index := int(float64(i) * interval)
-				if i == limit-1 {
-					index = length - 1 // Ensure last index is always included
-				}
-				if index >= length {
-					index = length - 1
-				}
-				selectedVersions = append(selectedVersions, versions[index]...)
+		return raw, err
+	},
+	ListingProgress: func(url string, total int64) func(read int64) {
+		description := "reading captures of " + url
+		bar := progressbar.DefaultBytes(total, description)
+		if quiet {
+			bar = progressbar.DefaultBytesSilent(total, description)
+		}
+		return func(read int64) {
+			bar.Set64(read)
+			if read == total {
+				bar.Finish()
 			}
 		}
+	},
+}
+
+// sendSnapshots queues versions for the workers reading jobCh and closes it.
+// It stops early once ctx is cancelled, as the workers stop reading then.
+func sendSnapshots(ctx context.Context, versions []snapshot, jobCh chan<- snapshot) {
+	defer close(jobCh)
+	for _, version := range versions {
+		select {
+		case jobCh <- version:
+		case <-ctx.Done():
+			return
+		}
 	}
-	return selectedVersions, nil
 }
 
-func GetRobotsTxtPaths(version string, url string, pathCh chan []string, bar *progressbar.ProgressBar) {
-	requestURL := fmt.Sprintf("https://web.archive.org/web/%sif_/%s/robots.txt", version, url)
-	res, err := http.Get(requestURL)
+// fetchPaths fetches the paths of a snapshot and sends them to pathCh,
+// reporting a failed fetch instead.
+func fetchPaths(ctx context.Context, version snapshot, netDisallowed bool, pathCh chan []robotsPath, bar *progressbar.ProgressBar) {
+	requestURL := waybackrobots.SnapshotURL(version.Timestamp, version.URL, version.Original)
+
+	// Fetched and parsed separately, rather than with GetRobotsTxtPaths, to
+	// keep the raw content for -debug
+	body, err := archive.Snapshot(ctx, version.Timestamp, version.URL, version.Original)
+	var paths []robotsPath
+	if err == nil {
+		paths, err = waybackrobots.SnapshotPaths(body, version.URL, version.Timestamp, netDisallowed)
+		if err != nil {
+			err = &waybackrobots.ParseError{URL: requestURL, Err: err}
+		}
+	}
 	bar.Add(1)
-	if err != nil || res.StatusCode != 200 {
+	if err != nil {
+		reportSnapshotError(ctx, err)
 		return
 	}
+	stats.snapshots.Add(1)
+	verbosef("Extracted %d paths from %s", len(paths), requestURL)
+	if len(paths) == 0 {
+		debugf("No rules in %s:\n%s", requestURL, body)
+	}
+	pathCh <- paths
+}
 
-	outputURLs := make([]string, 0)
-	defer res.Body.Close()
+// fetchRules fetches and parses a snapshot for the timeline, reporting a
+// failed fetch and returning no rules and the error instead.
+func fetchRules(ctx context.Context, version string, u string, original string, bar *progressbar.ProgressBar) (ParsedRobots, string, error) {
+	parsed, rawContent, err := archive.GetRobotsTxtPathsForTimeline(ctx, version, u, original)
+	bar.Add(1)
+	if err != nil {
+		reportSnapshotError(ctx, err)
+		return ParsedRobots{}, "", err
+	}
+	requestURL := waybackrobots.SnapshotURL(version, u, original)
+	verbosef("Parsed rules of %d user-agents from %s", len(parsed.Rules), requestURL)
+	if len(parsed.Rules) == 0 {
+		debugf("No rules in %s:\n%s", requestURL, rawContent)
+	}
+	stats.snapshots.Add(1)
+	return parsed, rawContent, nil
+}
 
-	scanner := bufio.NewScanner(res.Body)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if strings.HasPrefix(line, "Disallow:") || strings.HasPrefix(line, "Allow:") {
-			fields := strings.Fields(line)
-			if len(fields) < 2 {
-				continue
-			}
-			path := strings.TrimSpace(fields[1])
-			if path != "" {
-				fullURL, err := mergeURLPath(url, path)
-				if err != nil {
-					continue
-				}
-				outputURLs = append(outputURLs, fullURL)
-			}
-		}
+// reportSnapshotError prints why a snapshot produced nothing. Fetches cut
+// short by an interrupt aren't worth reporting.
+func reportSnapshotError(ctx context.Context, err error) {
+	var parseErr *waybackrobots.ParseError
+	if errors.As(err, &parseErr) {
+		stats.snapshots.Add(1) // It was fetched after all
+		fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", parseErr.URL, parseErr.Err)
+		return
 	}
+	if ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "Error %v\n", err)
+	}
+}
 
-	if err := scanner.Err(); err != nil {
+// sleepJitter sleeps for a random duration up to max.
+func sleepJitter(max time.Duration) {
+	if max <= 0 {
 		return
 	}
-	pathCh <- outputURLs
+	time.Sleep(time.Duration(rand.Int63n(int64(max))))
 }
 
-// GetRobotsTxtPathsForTimeline parses a robots.txt version and returns its rules and raw content.
-func GetRobotsTxtPathsForTimeline(version string, u string, bar *progressbar.ProgressBar) (AgentRules, string) {
-	requestURL := fmt.Sprintf("https://web.archive.org/web/%sif_/%s/robots.txt", version, u)
-	res, err := http.Get(requestURL)
-	bar.Add(1)
+func reverseStrings(s []string) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
+
+// yearRange is the value of -year, either a single year or a range such as
+// 2019-2023. Both ends are zero when it isn't set.
+type yearRange struct {
+	from, to int
+}
+
+func (r *yearRange) String() string {
+	if r == nil || r.from == 0 {
+		return "0"
+	}
+	if r.to == r.from {
+		return strconv.Itoa(r.from)
+	}
+	return fmt.Sprintf("%d-%d", r.from, r.to)
+}
+
+func (r *yearRange) Set(value string) error {
+	fromStr, toStr, isRange := strings.Cut(value, "-")
+	from, err := strconv.Atoi(fromStr)
 	if err != nil {
-		return nil, ""
+		return fmt.Errorf("expected a year or a range of years like 2019-2023")
+	}
+	to := from
+	if isRange {
+		if to, err = strconv.Atoi(toStr); err != nil {
+			return fmt.Errorf("expected a year or a range of years like 2019-2023")
+		}
 	}
-	if res.StatusCode != 200 {
-		res.Body.Close()
-		return nil, ""
+	if from < 0 || to < from {
+		return fmt.Errorf("the range of years must go forward, e.g. 2019-2023")
 	}
+	r.from, r.to = from, to
+	return nil
+}
+
+// yearLabel names the -year selection in output paths and messages, e.g.
+// "2023" or "2019-2023".
+func yearLabel(opts options) string {
+	return (&yearRange{from: opts.year, to: opts.toYear}).String()
+}
 
-	defer res.Body.Close()
-	body, err := ioutil.ReadAll(res.Body)
+// regexpList is a flag holding every regular expression it was given.
+type regexpList []*regexp.Regexp
+
+func (l *regexpList) String() string {
+	if l == nil {
+		return ""
+	}
+	patterns := make([]string, 0, len(*l))
+	for _, re := range *l {
+		patterns = append(patterns, re.String())
+	}
+	return strings.Join(patterns, " ")
+}
+
+// Set compiles the pattern, so an invalid one is reported while parsing flags.
+func (l *regexpList) Set(pattern string) error {
+	re, err := regexp.Compile(pattern)
 	if err != nil {
-		return nil, ""
+		return err
 	}
-	rawContent := string(body)
-	allRules := make(AgentRules)
+	*l = append(*l, re)
+	return nil
+}
 
-	var currentAgents []string
-	lastDirectiveWasAgent := false
+// matchesPathFilters reports whether path passes -include and -exclude: it
+// matches none of exclude and, if any are given, one of include.
+func matchesPathFilters(path string, include, exclude regexpList) bool {
+	for _, re := range exclude {
+		if re.MatchString(path) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, re := range include {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
 
-	scanner := bufio.NewScanner(strings.NewReader(rawContent))
+// readURLs reads one input URL per line, skipping blank lines and comments
+// starting with "#".
+func readURLs(r io.Reader) ([]string, error) {
+	var urls []string
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
-		if strings.HasPrefix(line, "#") || line == "" {
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
 
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) != 2 {
+// loadWordlist reads a newline-separated list of paths into a set keyed by
+// wordlistKey.
+func loadWordlist(filePath string) (map[string]bool, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	known := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
 			continue
 		}
+		known[strings.Trim(line, "/")] = true
+	}
+	return known, scanner.Err()
+}
 
-		directive := strings.ToLower(strings.TrimSpace(parts[0]))
-		value := strings.TrimSpace(parts[1])
-
-		switch directive {
-		case "user-agent":
-			if !lastDirectiveWasAgent {
-				// This is the start of a new agent group, clear the previous list.
-				currentAgents = []string{}
-			}
-			currentAgents = append(currentAgents, value)
-			lastDirectiveWasAgent = true
-		case "allow", "disallow":
-			if len(currentAgents) == 0 {
-				continue // Rule without a user-agent
-			}
-			// Use the raw path from the file, but create a full URL for comparison
-			// Note: The diff logic relies on paths being consistent.
-			// Using the merged URL path ensures "path" and "/path" are treated same.
-			fullPath, err := mergeURLPath(u, value)
-			if err != nil {
-				continue
-			}
-			for _, agent := range currentAgents {
-				if _, ok := allRules[agent]; !ok {
-					allRules[agent] = make(RuleSet)
-				}
-				// Store the full path for consistent diffing
-				allRules[agent][fullPath] = directive
-			}
-			lastDirectiveWasAgent = false
-		default:
-			// Any other directive (like Sitemap) also breaks an agent group.
-			lastDirectiveWasAgent = false
-		}
+// wordlistKey reduces a discovered URL to the form used by wordlist entries:
+// its path and query without surrounding slashes.
+func wordlistKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
 	}
-	return allRules, rawContent
+	return strings.Trim(u.RequestURI(), "/")
 }
 
-func mergeURLPath(baseURL, path string) (string, error) {
-	base, err := url.Parse(baseURL)
+// pathLength returns the length of the path component of a URL.
+func pathLength(rawURL string) int {
+	u, err := url.Parse(rawURL)
 	if err != nil {
-		return "", err
+		return len(rawURL)
+	}
+	return len(u.Path)
+}
+
+// relativePath returns the path and query of a URL without its scheme and
+// host. Like removeQuery, it cuts the URL rather than re-encoding it.
+func relativePath(rawURL string) string {
+	i := strings.Index(rawURL, "://")
+	if i < 0 {
+		return rawURL
+	}
+	if j := strings.Index(rawURL[i+3:], "/"); j >= 0 {
+		return rawURL[i+3+j:]
 	}
+	return "/"
+}
 
-	if !strings.HasPrefix(path, "/") {
-		path = "/" + path
+// absolutePath undoes -relative for a path of u, for links that need a full
+// URL.
+func absolutePath(u, path string, opts options) string {
+	if opts.relative && strings.HasPrefix(path, "/") {
+		return u + path
 	}
+	return path
+}
 
-	// Use ResolveReference to correctly handle paths
-	pathURL, err := url.Parse(path)
+// removeQuery drops the query string of a URL. The URL is cut rather than
+// parsed and re-encoded, so wildcards such as /*.php$ are left as they are.
+func removeQuery(rawURL string) string {
+	if i := strings.Index(rawURL, "?"); i >= 0 {
+		return rawURL[:i]
+	}
+	return rawURL
+}
+
+// inputDomain returns the host of an input URL, or the input itself if it
+// can't be parsed.
+func inputDomain(rawURL string) string {
+	u, err := cleanURL(rawURL)
 	if err != nil {
-		return "", err
+		return rawURL
 	}
+	return getHost(u)
+}
 
-	resolvedURL := base.ResolveReference(pathURL)
-	return resolvedURL.String(), nil
+// dedupeInputs drops inputs that clean to a host already seen, such as the
+// same domain with a different scheme or a trailing slash.
+func dedupeInputs(urls []string) []string {
+	seen := make(map[string]bool)
+	deduped := make([]string, 0, len(urls))
+	skipped := 0
+	for _, rawURL := range urls {
+		u, err := cleanURL(rawURL)
+		if err != nil {
+			// Leave it to processDomain to report the bad input
+			deduped = append(deduped, rawURL)
+			continue
+		}
+		host := getHost(u)
+		if seen[host] {
+			skipped++
+			continue
+		}
+		seen[host] = true
+		deduped = append(deduped, rawURL)
+	}
+	if skipped > 0 {
+		infof("Skipped %d duplicate input URLs\n", skipped)
+	}
+	return deduped
 }
 
 func getHost(rawURL string) string {
@@ -827,6 +1763,14 @@ func getHost(rawURL string) string {
 	return u.Host
 }
 
+// withScheme replaces the scheme of a base URL.
+func withScheme(u, scheme string) string {
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+	}
+	return scheme + "://" + u
+}
+
 func cleanURL(baseURL string) (string, error) {
 	// Trim protocol if present for parsing
 	cleanBase := strings.TrimPrefix(strings.TrimPrefix(baseURL, "https://"), "http://")
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ndjsonPath is a line of -format ndjson.
+type ndjsonPath struct {
+	URL       string `json:"url"`
+	Directive string `json:"directive"`
+	Agent     string `json:"agent"`
+}
+
+// ndjsonWriter streams the paths of a domain as they are found, one JSON
+// object per line, to paths.ndjson or stdout. The file is only created once
+// the first path arrives.
+type ndjsonWriter struct {
+	u        string
+	opts     options
+	file     *os.File
+	filePath string
+	lines    int
+}
+
+func newNDJSONWriter(u string, opts options) *ndjsonWriter {
+	return &ndjsonWriter{u: u, opts: opts}
+}
+
+func (w *ndjsonWriter) write(row pathRow) {
+	line, err := json.Marshal(ndjsonPath{URL: row.path, Directive: row.directive, Agent: row.agent})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding %s: %v\n", row.path, err)
+		return
+	}
+	line = append(line, '\n')
+	w.lines++
+
+	if w.opts.outputDir == "" {
+		// Unbuffered, so every line reaches the pipe right away
+		output.submit(func() { os.Stdout.Write(line) })
+		return
+	}
+	if w.file == nil && !w.open() {
+		return
+	}
+	if _, err := w.file.Write(line); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing to %s: %v\n", w.filePath, err)
+	}
+}
+
+func (w *ndjsonWriter) open() bool {
+	dirPath := filepath.Join(w.opts.outputDir, getHost(w.u))
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", dirPath, err)
+		return false
+	}
+	w.filePath = filepath.Join(dirPath, "paths.ndjson")
+	file, err := os.Create(w.filePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating %s: %v\n", w.filePath, err)
+		return false
+	}
+	w.file = file
+	return true
+}
+
+// close finishes the file, creating an empty one with -write-empty when no
+// path was found.
+func (w *ndjsonWriter) close() {
+	if w.lines == 0 {
+		if !w.opts.writeEmpty {
+			infof("No paths found for %s\n", getHost(w.u))
+			return
+		}
+		if w.opts.outputDir == "" || !w.open() {
+			return
+		}
+	}
+	if w.file == nil {
+		return
+	}
+	if err := w.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing to %s: %v\n", w.filePath, err)
+	} else {
+		infof("Wrote paths to %s\n", w.filePath)
+	}
+}
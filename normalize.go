@@ -0,0 +1,144 @@
+package main
+
+import (
+	"strings"
+)
+
+// normalizeEncoding canonicalizes the percent-encoding of the path of rawURL
+// so that spellings a server would most likely treat as the same resource
+// collapse into a single entry:
+//
+//	/my+path    -> /my%20path  ("+" is treated as an encoded space)
+//	/my%20path  -> /my%20path
+//	/a%2fb      -> /a%2Fb      (escapes are uppercased, "/" stays encoded)
+//	/%7Euser    -> /~user      (unreserved characters are decoded)
+//
+// Robots.txt wildcards ("*" and "$") and the query string are left untouched.
+// A literal space never gets here, as a rule's path ends at the first one.
+func normalizeEncoding(rawURL string) string {
+	start := 0
+	if i := strings.Index(rawURL, "://"); i >= 0 {
+		start = i + 3
+		if j := strings.Index(rawURL[start:], "/"); j >= 0 {
+			start += j
+		} else {
+			return rawURL // No path
+		}
+	}
+	end := len(rawURL)
+	if i := strings.IndexAny(rawURL[start:], "?#"); i >= 0 {
+		end = start + i
+	}
+
+	var b strings.Builder
+	b.WriteString(rawURL[:start])
+	path := rawURL[start:end]
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '+':
+			b.WriteString("%20")
+		case c == '%' && i+2 < len(path) && isHex(path[i+1]) && isHex(path[i+2]):
+			decoded := unhex(path[i+1])<<4 | unhex(path[i+2])
+			if isUnreserved(decoded) {
+				b.WriteByte(decoded)
+			} else {
+				b.WriteByte('%')
+				b.WriteString(strings.ToUpper(path[i+1 : i+3]))
+			}
+			i += 2
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString(rawURL[end:])
+	return b.String()
+}
+
+// pathKey folds the spellings of rawURL that -normalize treats as the same
+// path: the path is lowercased and trailing slashes are dropped, so /Admin,
+// /admin and /admin/ share a key. The host and query string are kept as is.
+func pathKey(rawURL string) string {
+	start := 0
+	if i := strings.Index(rawURL, "://"); i >= 0 {
+		start = i + 3
+		if j := strings.Index(rawURL[start:], "/"); j >= 0 {
+			start += j
+		} else {
+			return rawURL // No path
+		}
+	}
+	end := len(rawURL)
+	if i := strings.IndexAny(rawURL[start:], "?#"); i >= 0 {
+		end = start + i
+	}
+
+	path := strings.ToLower(rawURL[start:end])
+	if trimmed := strings.TrimRight(path, "/"); trimmed != "" {
+		path = trimmed
+	} else if path != "" {
+		path = "/" // The root itself
+	}
+	return rawURL[:start] + path + rawURL[end:]
+}
+
+// pathSpellings maps every -normalize key to the first spelling of it found,
+// which stands in for the others in the output. The original casing is kept
+// since servers are often case-sensitive.
+type pathSpellings map[string]string
+
+// representative returns the spelling path is deduplicated to.
+func (s pathSpellings) representative(path string) string {
+	key := pathKey(path)
+	if spelling, ok := s[key]; ok {
+		return spelling
+	}
+	s[key] = path
+	return path
+}
+
+// normalizeRules applies normalizeEncoding to every path of rules. When two
+// spellings of a path carry different directives, disallow wins.
+func normalizeRules(parsed ParsedRobots) ParsedRobots {
+	rules := make(AgentRules, len(parsed.Rules))
+	for agent, ruleSet := range parsed.Rules {
+		rules[agent] = make(RuleSet, len(ruleSet))
+		for path, directive := range ruleSet {
+			path = normalizeEncoding(path)
+			if rules[agent][path] != "disallow" {
+				rules[agent][path] = directive
+			}
+		}
+	}
+
+	order := make(AgentOrder, len(parsed.Order))
+	for agent, orderedRules := range parsed.Order {
+		for _, rule := range orderedRules {
+			rule.Path = normalizeEncoding(rule.Path)
+			order[agent] = append(order[agent], rule)
+		}
+	}
+	return ParsedRobots{Rules: rules, Order: order, Delays: parsed.Delays, Sitemaps: parsed.Sitemaps, Ignored: parsed.Ignored}
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// isUnreserved reports whether c is an RFC 3986 unreserved character, which
+// means the same thing encoded or not.
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestNormalizeEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"unchanged", "https://example.com/admin", "https://example.com/admin"},
+		{"plus as space", "https://example.com/my+path", "https://example.com/my%20path"},
+		{"encoded space", "https://example.com/my%20path", "https://example.com/my%20path"},
+		{"lowercase escape is uppercased", "https://example.com/a%2fb", "https://example.com/a%2Fb"},
+		{"mixed case escape is uppercased", "https://example.com/a%c3%A9", "https://example.com/a%C3%A9"},
+		{"unreserved tilde is decoded", "https://example.com/%7Euser", "https://example.com/~user"},
+		{"unreserved letters and digits are decoded", "https://example.com/%41%62%30", "https://example.com/Ab0"},
+		{"unreserved punctuation is decoded", "https://example.com/%2D%2E%5F", "https://example.com/-._"},
+		{"reserved slash stays encoded", "https://example.com/a%2Fb", "https://example.com/a%2Fb"},
+		{"reserved question mark stays encoded", "https://example.com/a%3Fb", "https://example.com/a%3Fb"},
+		{"reserved hash stays encoded", "https://example.com/a%23b", "https://example.com/a%23b"},
+		{"percent sign stays encoded", "https://example.com/100%25", "https://example.com/100%25"},
+		{"invalid escape is left alone", "https://example.com/100%zz", "https://example.com/100%zz"},
+		{"truncated escape is left alone", "https://example.com/a%2", "https://example.com/a%2"},
+		{"trailing percent is left alone", "https://example.com/a%", "https://example.com/a%"},
+		{"wildcards are kept", "https://example.com/*.php$", "https://example.com/*.php$"},
+		{"query is untouched", "https://example.com/a%7e?q=a+b%7e", "https://example.com/a~?q=a+b%7e"},
+		{"no path", "https://example.com", "https://example.com"},
+		{"relative path", "/my+path/%7e", "/my%20path/~"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeEncoding(tt.in); got != tt.want {
+				t.Errorf("normalizeEncoding(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/mhmdiaa/waybackrobots/pkg/waybackrobots"
+)
+
+// printParsedFile parses a local robots.txt file and prints what the parser
+// made of it, for reproducing parser bugs without touching the network.
+func printParsedFile(filePath string) error {
+	raw, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	// An empty base URL keeps the paths relative
+	parsed := waybackrobots.Parse(string(raw), "")
+
+	agents := make([]string, 0, len(parsed.Order))
+	for agent := range parsed.Order {
+		agents = append(agents, agent)
+	}
+	for agent := range parsed.Delays {
+		if _, ok := parsed.Order[agent]; !ok {
+			agents = append(agents, agent) // Only sets a delay
+		}
+	}
+	for agent := range parsed.Rules {
+		_, hasOrder := parsed.Order[agent]
+		_, hasDelay := parsed.Delays[agent]
+		if !hasOrder && !hasDelay {
+			agents = append(agents, agent) // Only an empty Disallow
+		}
+	}
+	sort.Strings(agents)
+
+	for _, agent := range agents {
+		fmt.Printf("User-agent: %s\n", agent)
+		if delay, ok := parsed.Delays[agent]; ok {
+			fmt.Printf("  Crawl-delay: %s\n", delay)
+		}
+		for _, rule := range parsed.Order[agent] {
+			fmt.Printf("  %s: %s\n", strings.ToUpper(rule.Directive[:1])+rule.Directive[1:], rule.Path)
+		}
+		if _, ok := parsed.Order[agent]; !ok {
+			if _, ok := parsed.Rules[agent]; ok {
+				fmt.Println("  Allows everything (empty Disallow)")
+			}
+		}
+	}
+	if len(agents) == 0 {
+		fmt.Println("No rules")
+	}
+
+	if len(parsed.Sitemaps) > 0 {
+		fmt.Println("\nSitemaps:")
+		for _, sitemap := range parsed.Sitemaps {
+			fmt.Printf("  %s\n", sitemap)
+		}
+	}
+	if len(parsed.Ignored) > 0 {
+		fmt.Println("\nIgnored lines:")
+		for _, line := range parsed.Ignored {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package waybackrobots
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// VersionQuery selects which robots.txt captures GetRobotsTxtVersions returns.
+type VersionQuery struct {
+	Limit  int    // Number of captures to return, -1 for all
+	Recent bool   // Take the most recent captures instead of distributing them
+	Year   int    // Only captures from this year, overrides Limit and Recent
+	Since  string // Only captures from this timestamp onwards
+
+	// With Year, only captures from Year through ToYear. Zero for Year alone.
+	ToYear int
+
+	// Only this many of the oldest and newest captures, overrides Limit and Recent
+	Endpoints int
+
+	// Read the CDX JSON response from this file instead of querying CDX
+	CDXFile string
+
+	// Keep only the last capture of each calendar day
+	LatestPerDay bool
+
+	// Keep only the first capture of every content digest, not just of every
+	// run of identical captures
+	DedupeDigest bool
+
+	// CDX matchType: "exact" (or empty), "prefix", "host" or "domain"
+	MatchType string
+
+	// Only captures within this range, as YYYYMMDD or YYYYMMDDhhmmss. Ignored
+	// when Year or Since is set. With both bounds, overrides Limit and Recent.
+	From string
+	To   string
+
+	// Request the CDX listing this many rows at a time, following CDX's resume
+	// keys, rather than all at once. Zero for a single request. Queries
+	// limited to the most recent captures are always a single request.
+	PageSize int
+}
+
+// lastYear returns the last year of a Year query.
+func (q VersionQuery) lastYear() int {
+	if q.ToYear > q.Year {
+		return q.ToYear
+	}
+	return q.Year
+}
+
+// Bounded reports whether the query selects every capture of a closed date
+// range rather than sampling them.
+func (q VersionQuery) Bounded() bool {
+	return q.From != "" && q.To != ""
+}
+
+// Capture is a single archived robots.txt as listed by CDX.
+type Capture struct {
+	Timestamp string
+	Original  string // URL the capture was archived under, e.g. with a query string
+	Digest    string // Content hash, only listed when deduplicating by digest
+}
+
+// CDXScope returns the CDX parameters selecting the robots.txt captures of the
+// base URL u for a match type:
+//
+//	exact   only <u>/robots.txt (the default)
+//	prefix  every URL starting with <u>/robots.txt, e.g. with a query string
+//	host    the root robots.txt of the host under any scheme or port
+//	domain  the root robots.txt of the host and all of its subdomains
+func CDXScope(u, matchType string) string {
+	switch matchType {
+	case "prefix":
+		return fmt.Sprintf("url=%s/robots.txt&matchType=prefix", u)
+	case "host", "domain":
+		// Only the root robots.txt of every host, not robots.txt deeper in a site
+		urlkeyFilter := url.QueryEscape(`urlkey:.*\)/robots\.txt$`)
+		return fmt.Sprintf("url=%s&matchType=%s&filter=%s", host(u), matchType, urlkeyFilter)
+	default:
+		return fmt.Sprintf("url=%s/robots.txt", u)
+	}
+}
+
+// LargeListing is the response size from which decoding a CDX listing
+// reports its progress, roughly 80,000 captures.
+const LargeListing = 4 << 20
+
+// listCaptures runs the CDX query requestURL for the robots.txt of u. With a
+// PageSize, the listing is requested a page at a time through CDX's resume
+// keys, so no single response has to hold a huge history and every page
+// fetched before a failure can be cached for the next attempt.
+func (c *Client) listCaptures(ctx context.Context, requestURL, u string, q VersionQuery) ([]Capture, error) {
+	paged := q.PageSize > 0 && !strings.Contains(requestURL, "&limit=")
+	pageURL := requestURL
+	if paged {
+		pageURL += fmt.Sprintf("&limit=%d&showResumeKey=true", q.PageSize)
+	}
+
+	var captures []Capture
+	for {
+		raw, err := c.fetchCDX(ctx, pageURL)
+		if err != nil {
+			return nil, newFetchError(pageURL, err)
+		}
+		page, resumeKey, err := c.decodeCaptures(raw, u, q)
+		if err != nil {
+			return nil, &ParseError{URL: pageURL, Err: err}
+		}
+		if captures == nil {
+			captures = page
+		} else {
+			captures = append(captures, page...)
+		}
+		if !paged || resumeKey == "" {
+			return captures, nil
+		}
+		pageURL = fmt.Sprintf("%s&limit=%d&showResumeKey=true&resumeKey=%s", requestURL, q.PageSize, url.QueryEscape(resumeKey))
+	}
+}
+
+// decodeCaptures reads the CDX JSON rows in raw one at a time into a reused
+// row, so a history of hundreds of thousands of captures costs a Capture each
+// instead of a slice per row on top of it. The columns are located by the
+// header row. Rows of a CDX file weren't filtered by CDX, so the from/to/limit
+// parameters GetRobotsTxtCaptures would have sent are applied here. A paged
+// listing ends with an empty row and the key to resume it from, which is
+// returned as well.
+func (c *Client) decodeCaptures(raw []byte, u string, q VersionQuery) ([]Capture, string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil { // Opening bracket
+		return nil, "", err
+	}
+	if !dec.More() {
+		return []Capture{}, "", nil
+	}
+
+	var header []string
+	if err := dec.Decode(&header); err != nil {
+		return nil, "", err
+	}
+	timestampIndex, originalIndex, digestIndex := -1, -1, -1
+	for i, field := range header {
+		switch field {
+		case "timestamp":
+			timestampIndex = i
+		case "original":
+			originalIndex = i
+		case "digest":
+			digestIndex = i
+		}
+	}
+	if timestampIndex == -1 {
+		return nil, "", fmt.Errorf("CDX listing has no timestamp field")
+	}
+
+	var from, to string
+	if q.CDXFile != "" {
+		from = q.Since
+		if q.Year > 0 {
+			yearFrom := fmt.Sprintf("%d0101000000", q.Year)
+			if yearFrom > from {
+				from = yearFrom
+			}
+			to = fmt.Sprintf("%d1231235959", q.lastYear())
+		} else if q.Since == "" {
+			from = q.From
+			if q.To != "" {
+				// Like CDX, a date-only bound includes the whole day
+				to = q.To + "999999"[:14-len(q.To)]
+			}
+		}
+	}
+
+	var progress func(read int64)
+	if len(raw) >= LargeListing && c.ListingProgress != nil {
+		progress = c.ListingProgress(u, int64(len(raw)))
+	}
+
+	// About 50 bytes per row, which saves growing the slice one capture at a time
+	captures := make([]Capture, 0, len(raw)/50)
+	var row []string
+	var resumeKey string
+	rows := 0 // Decoded rows, filtered out or not, to pace the progress
+	for dec.More() {
+		row = row[:0]
+		if err := dec.Decode(&row); err != nil {
+			return nil, "", err
+		}
+		if len(row) == 0 {
+			// Only the resume key follows
+			if dec.More() {
+				if err := dec.Decode(&row); err != nil {
+					return nil, "", err
+				}
+				if len(row) > 0 {
+					resumeKey = row[0]
+				}
+			}
+			break
+		}
+		rows++
+		if progress != nil && rows%10000 == 0 {
+			progress(dec.InputOffset())
+		}
+		if len(row) <= timestampIndex {
+			continue
+		}
+		capture := Capture{Timestamp: row[timestampIndex]}
+		if (from != "" && capture.Timestamp < from) || (to != "" && capture.Timestamp > to) {
+			continue
+		}
+		if originalIndex != -1 && len(row) > originalIndex {
+			capture.Original = row[originalIndex]
+		}
+		if q.DedupeDigest && digestIndex != -1 && len(row) > digestIndex {
+			capture.Digest = row[digestIndex]
+		}
+		captures = append(captures, capture)
+	}
+	if progress != nil {
+		progress(int64(len(raw)))
+	}
+
+	if q.CDXFile != "" && q.Year == 0 && q.Since == "" && !q.Bounded() && q.Endpoints == 0 && q.Recent && q.Limit != -1 && len(captures) > q.Limit {
+		captures = captures[len(captures)-q.Limit:]
+	}
+	return captures, resumeKey, nil
+}
+
+// firstPerDigest keeps the first of the sorted captures of every content
+// digest. CDX's collapse=digest only drops adjacent duplicates, so a file that
+// changes back and forth would otherwise be fetched once per change. Captures
+// without a digest are all kept.
+func firstPerDigest(captures []Capture) []Capture {
+	seen := make(map[string]bool)
+	kept := captures[:0]
+	for _, capture := range captures {
+		if capture.Digest != "" {
+			if seen[capture.Digest] {
+				continue
+			}
+			seen[capture.Digest] = true
+		}
+		kept = append(kept, capture)
+	}
+	return kept
+}
+
+// latestPerDay keeps the last of the sorted captures of each YYYYMMDD day.
+func latestPerDay(captures []Capture) []Capture {
+	kept := captures[:0]
+	for i, capture := range captures {
+		if len(capture.Timestamp) < 8 {
+			continue
+		}
+		if i+1 < len(captures) && strings.HasPrefix(captures[i+1].Timestamp, capture.Timestamp[:8]) {
+			continue // A later capture on the same day follows
+		}
+		kept = append(kept, capture)
+	}
+	return kept
+}
+
+// host returns the host of a base URL, or the URL itself if it doesn't parse.
+func host(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Host
+}
@@ -0,0 +1,248 @@
+// Package waybackrobots retrieves the archived versions of a site's
+// robots.txt from the Wayback Machine and parses their rules.
+package waybackrobots
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Fetcher GETs a URL and returns the body of a 200 response. Other responses
+// should be reported as a *StatusError, or an error wrapping one, so callers
+// can tell them apart from network errors. The request should be abandoned
+// once ctx is done.
+type Fetcher func(ctx context.Context, url string) ([]byte, error)
+
+// HTTPFetcher is a Fetcher using client without caching or retries.
+func HTTPFetcher(client *http.Client) Fetcher {
+	return func(ctx context.Context, url string) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, &StatusError{StatusCode: resp.StatusCode, URL: url}
+		}
+		return ioutil.ReadAll(resp.Body)
+	}
+}
+
+// Client queries the Wayback Machine. The zero value uses http.DefaultClient.
+type Client struct {
+	Fetch    Fetcher // Snapshots
+	FetchCDX Fetcher // CDX listings, Fetch if nil
+
+	// ListingProgress, when set, is called before a CDX listing of at least
+	// LargeListing bytes is decoded. The returned func is called with the
+	// bytes decoded so far, and finally with total.
+	ListingProgress func(url string, total int64) func(read int64)
+}
+
+func (c *Client) fetch(ctx context.Context, url string) ([]byte, error) {
+	if c.Fetch == nil {
+		return HTTPFetcher(http.DefaultClient)(ctx, url)
+	}
+	return c.Fetch(ctx, url)
+}
+
+func (c *Client) fetchCDX(ctx context.Context, url string) ([]byte, error) {
+	if c.FetchCDX == nil {
+		return c.fetch(ctx, url)
+	}
+	return c.FetchCDX(ctx, url)
+}
+
+// GetRobotsTxtVersions returns the snapshot timestamps of url selected by q.
+func (c *Client) GetRobotsTxtVersions(ctx context.Context, url string, q VersionQuery) ([]string, error) {
+	captures, err := c.GetRobotsTxtCaptures(ctx, url, q)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]string, 0, len(captures))
+	for _, capture := range captures {
+		versions = append(versions, capture.Timestamp)
+	}
+	return versions, nil
+}
+
+// GetRobotsTxtCaptures returns the captures of url selected by q, with the
+// original URL of each so it can be fetched exactly as it was archived. A
+// listing that couldn't be retrieved is reported as a *FetchError and one
+// that couldn't be decoded as a *ParseError.
+func (c *Client) GetRobotsTxtCaptures(ctx context.Context, url string, q VersionQuery) ([]Capture, error) {
+	var requestURL string
+
+	scope := CDXScope(url, q.MatchType)
+	fields := "timestamp,original"
+	if q.DedupeDigest {
+		fields += ",digest"
+	}
+	if q.Year > 0 {
+		// Year is specified, override limit/recent and use from/to
+		from := fmt.Sprintf("%d0101000000", q.Year)
+		to := fmt.Sprintf("%d1231235959", q.lastYear())
+		requestURL = fmt.Sprintf("https://web.archive.org/cdx/search/cdx?%s&output=json&fl=%s&filter=statuscode:200&collapse=digest&from=%s&to=%s", scope, fields, from, to)
+	} else if q.Since != "" {
+		// Incremental run, fetch everything newer than the last run
+		requestURL = fmt.Sprintf("https://web.archive.org/cdx/search/cdx?%s&output=json&fl=%s&filter=statuscode:200&collapse=digest&from=%s", scope, fields, q.Since)
+	} else {
+		// No year, use original logic
+		requestURL = fmt.Sprintf("https://web.archive.org/cdx/search/cdx?%s&output=json&fl=%s&filter=statuscode:200&collapse=digest", scope, fields)
+		if q.From != "" {
+			requestURL += "&from=" + q.From
+		}
+		if q.To != "" {
+			requestURL += "&to=" + q.To
+		}
+		if q.Limit != -1 && q.Recent && q.Endpoints == 0 && !q.Bounded() {
+			requestURL += "&limit=-" + strconv.Itoa(q.Limit)
+		}
+	}
+	if q.Year > 0 && q.Since > fmt.Sprintf("%d0101000000", q.Year) {
+		// Incremental run within a year, skip what the last run already saw
+		requestURL = strings.Replace(requestURL, fmt.Sprintf("from=%d0101000000", q.Year), "from="+q.Since, 1)
+	}
+
+	var captures []Capture
+	if q.CDXFile != "" {
+		raw, err := ioutil.ReadFile(q.CDXFile)
+		if err != nil {
+			return nil, err
+		}
+		if captures, _, err = c.decodeCaptures(raw, url, q); err != nil {
+			return nil, &ParseError{URL: q.CDXFile, Err: err}
+		}
+	} else {
+		var err error
+		if captures, err = c.listCaptures(ctx, requestURL, url, q); err != nil {
+			return nil, err
+		}
+	}
+	if q.LatestPerDay {
+		captures = latestPerDay(captures)
+	}
+	if q.DedupeDigest {
+		captures = firstPerDigest(captures)
+	}
+
+	length := len(captures)
+	if q.Endpoints > 0 {
+		// Only the oldest and newest captures, ignoring the middle
+		if length <= 2*q.Endpoints {
+			return captures, nil
+		}
+		selected := make([]Capture, 0, 2*q.Endpoints)
+		selected = append(selected, captures[:q.Endpoints]...)
+		return append(selected, captures[length-q.Endpoints:]...), nil
+	}
+	if q.Year > 0 || q.Since != "" || q.Bounded() || q.Recent || q.Limit == -1 || length <= q.Limit {
+		// Every capture CDX returned was asked for
+		return captures, nil
+	}
+
+	if q.Limit == 1 {
+		// Nothing to distribute, and no interval to step by
+		return []Capture{captures[length-1]}, nil
+	}
+
+	// Distribute the limit evenly over the history. The selection is copied
+	// so the full list can be freed.
+	selected := make([]Capture, 0, q.Limit)
+	interval := float64(length) / float64(q.Limit-1)
+	for i := 0; i < q.Limit; i++ {
+		index := int(float64(i) * interval)
+		if i == q.Limit-1 {
+			index = length - 1 // Ensure last index is always included
+		}
+		if index >= length {
+			index = length - 1
+		}
+		selected = append(selected, captures[index])
+	}
+	return selected, nil
+}
+
+// GetRobotsTxtPaths fetches the robots.txt captured at version and returns its
+// paths. original is the URL CDX listed the capture under, see SnapshotURL.
+// With netDisallowed, only the Disallow paths still blocked after Allow
+// overrides are returned, along with the sitemaps. Failures are reported as
+// in GetRobotsTxtPathsForTimeline.
+func (c *Client) GetRobotsTxtPaths(ctx context.Context, version string, url string, original string, netDisallowed bool) ([]Path, error) {
+	body, err := c.Snapshot(ctx, version, url, original)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := SnapshotPaths(body, url, version, netDisallowed)
+	if err != nil {
+		return nil, &ParseError{URL: SnapshotURL(version, url, original), Err: err}
+	}
+	return paths, nil
+}
+
+// SnapshotPaths returns the paths of a robots.txt fetched with Snapshot, as
+// GetRobotsTxtPaths does.
+func SnapshotPaths(body []byte, url string, version string, netDisallowed bool) ([]Path, error) {
+	if !netDisallowed {
+		return ExtractPaths(body, url, version)
+	}
+
+	// Resolving Allow overrides needs the rules grouped per agent
+	parsed := Parse(string(body), url)
+	paths := make([]Path, 0)
+	for _, path := range NetDisallowedPaths(parsed.Rules) {
+		paths = append(paths, Path{URL: path, Directive: "disallow", Timestamp: version})
+	}
+	for _, value := range parsed.Sitemaps {
+		if sitemap, err := SitemapURL(url, value); err == nil {
+			paths = append(paths, Path{URL: sitemap, Directive: "sitemap", Timestamp: version})
+		}
+	}
+	return paths, nil
+}
+
+// GetRobotsTxtPathsForTimeline fetches the robots.txt captured at version and
+// returns its rules and raw content. A snapshot that couldn't be retrieved is
+// reported as a *FetchError, and one that isn't a robots.txt as a *ParseError
+// wrapping ErrHTMLPage.
+func (c *Client) GetRobotsTxtPathsForTimeline(ctx context.Context, version string, u string, original string) (ParsedRobots, string, error) {
+	body, err := c.Snapshot(ctx, version, u, original)
+	if err != nil {
+		return ParsedRobots{}, "", err
+	}
+	rawContent := string(body)
+	return Parse(rawContent, u), rawContent, nil
+}
+
+// Snapshot fetches the raw robots.txt captured at version, failing like
+// GetRobotsTxtPathsForTimeline.
+func (c *Client) Snapshot(ctx context.Context, version, u, original string) ([]byte, error) {
+	requestURL := SnapshotURL(version, u, original)
+	body, err := c.fetch(ctx, requestURL)
+	if err != nil {
+		return nil, newFetchError(requestURL, err)
+	}
+	if IsHTMLPage(body) {
+		return nil, &ParseError{URL: requestURL, Err: ErrHTMLPage}
+	}
+	return body, nil
+}
+
+// SnapshotURL returns the archive URL of the raw robots.txt captured at
+// version. original is the URL CDX listed the capture under; when it is empty
+// the standard <u>/robots.txt location is assumed.
+func SnapshotURL(version, u, original string) string {
+	if original == "" {
+		original = u + "/robots.txt"
+	}
+	return fmt.Sprintf("https://web.archive.org/web/%sif_/%s", version, original)
+}
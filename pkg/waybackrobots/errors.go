@@ -0,0 +1,61 @@
+package waybackrobots
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrHTMLPage is returned, wrapped in a ParseError, for a snapshot the archive
+// served as an HTML page, such as its calendar or an error page, instead of
+// the robots.txt.
+var ErrHTMLPage = errors.New("archive returned an HTML page instead of robots.txt")
+
+// StatusError is returned by a Fetcher for a response other than 200 OK.
+type StatusError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d for %s", e.StatusCode, e.URL)
+}
+
+// FetchError is returned when a snapshot or CDX listing couldn't be
+// retrieved, whether the request failed or the archive answered with an
+// error status.
+type FetchError struct {
+	URL        string
+	StatusCode int // Zero when no response was received
+	Err        error
+}
+
+func newFetchError(url string, err error) *FetchError {
+	fetchErr := &FetchError{URL: url, Err: err}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		fetchErr.StatusCode = statusErr.StatusCode
+	}
+	return fetchErr
+}
+
+func (e *FetchError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("fetching %s: status %d", e.URL, e.StatusCode)
+	}
+	return fmt.Sprintf("fetching %s: %v", e.URL, e.Err)
+}
+
+func (e *FetchError) Unwrap() error { return e.Err }
+
+// ParseError is returned when a fetched robots.txt or CDX listing couldn't
+// be read.
+type ParseError struct {
+	URL string
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parsing %s: %v", e.URL, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
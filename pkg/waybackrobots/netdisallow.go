@@ -0,0 +1,64 @@
+package waybackrobots
+
+import (
+	"sort"
+	"strings"
+)
+
+// NetDisallowedPaths returns the Disallow paths that stay blocked for at
+// least one agent after its Allow rules are applied. Like major crawlers, the
+// longest matching rule wins and Allow wins a tie, so "Disallow: /private"
+// is overridden by "Allow: /private" but not by "Allow: /".
+func NetDisallowedPaths(rules AgentRules) []string {
+	blocked := make(map[string]bool)
+	for _, ruleSet := range rules {
+		for path, directive := range ruleSet {
+			if directive != "disallow" || blocked[path] {
+				continue
+			}
+			overridden := false
+			for allowPath, allowDirective := range ruleSet {
+				if allowDirective == "allow" && len(allowPath) >= len(path) && PatternMatch(allowPath, path) {
+					overridden = true
+					break
+				}
+			}
+			if !overridden {
+				blocked[path] = true
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(blocked))
+	for path := range blocked {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// PatternMatch reports whether a robots.txt rule pattern matches path.
+// "*" matches any sequence of characters and a trailing "$" anchors the
+// pattern to the end of the path; otherwise the pattern is a prefix.
+func PatternMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			// The last part has to sit at the very end
+			return strings.HasSuffix(rest, part)
+		}
+		idx := strings.Index(rest, part)
+		if idx < 0 {
+			return false
+		}
+		rest = rest[idx+len(part):]
+	}
+	return !anchored || rest == ""
+}
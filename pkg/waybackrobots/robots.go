@@ -0,0 +1,408 @@
+package waybackrobots
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// RuleSet holds the paths and their directive (allow/disallow) for a specific user-agent.
+type RuleSet map[string]string // Key: path, Value: "allow" or "disallow"
+
+// AgentRules holds the rules for all user-agents in a robots.txt file.
+type AgentRules map[string]RuleSet // Key: user-agent
+
+// AgentDelays holds the Crawl-delay of every user-agent that sets one.
+type AgentDelays map[string]string // Key: user-agent, Value: delay as written
+
+// OrderedRule is a single Allow/Disallow rule as it appeared in the file.
+type OrderedRule struct {
+	Directive string `json:"directive"`
+	Path      string `json:"path"`
+}
+
+// AgentOrder holds the rules of every user-agent in file order, which matters
+// for how crawlers resolve overlapping rules.
+type AgentOrder map[string][]OrderedRule // Key: user-agent
+
+// ParsedRobots is the result of parsing a robots.txt file.
+type ParsedRobots struct {
+	Rules    AgentRules
+	Order    AgentOrder
+	Delays   AgentDelays
+	Sitemaps []string
+	Ignored  []string // Lines that didn't contribute a rule, prefixed with their line number
+}
+
+// VersionContent holds the timestamp, rules, and raw content from a robots.txt version.
+type VersionContent struct {
+	Timestamp  string
+	URL        string // Base URL the version was captured under
+	Original   string // Exact robots.txt URL from CDX, empty if unknown
+	Rules      AgentRules
+	Order      AgentOrder
+	Delays     AgentDelays
+	Sitemaps   []string // Sitemap URLs, sorted. They apply to every agent.
+	RawContent string   // Store the raw text content
+}
+
+// Path is a single Allow/Disallow path, or Sitemap, found in a robots.txt version.
+type Path struct {
+	URL       string
+	Directive string // "allow", "disallow" or "sitemap"
+	Agent     string // User-agent the rule applies to, empty if it wasn't in a group
+	Timestamp string // Snapshot the path was found in
+}
+
+// Parse parses the Allow/Disallow rules of every user-agent in a
+// robots.txt file. Paths are merged with the base URL u.
+func Parse(rawContent string, u string) ParsedRobots {
+	allRules := make(AgentRules)
+	order := make(AgentOrder)
+	delays := make(AgentDelays)
+
+	var sitemaps, ignored []string
+
+	var currentAgents []string
+	lastDirectiveWasAgent := false
+	names := make(agentNames)
+
+	lineNumber := 0
+	scanner := bufio.NewScanner(strings.NewReader(strings.TrimPrefix(rawContent, bom)))
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		ignore := func() {
+			ignored = append(ignored, fmt.Sprintf("%d: %s", lineNumber, strings.TrimSpace(line)))
+		}
+
+		directive, value, ok := SplitDirective(line)
+		if !ok {
+			if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+				ignore()
+			}
+			continue
+		}
+
+		switch directive {
+		case "user-agent":
+			if value == "" {
+				// Neither starts a group nor ends the current one
+				ignore()
+				continue
+			}
+			if !lastDirectiveWasAgent {
+				// This is the start of a new agent group, clear the previous list.
+				currentAgents = []string{}
+			}
+			currentAgents = append(currentAgents, names.canonical(value))
+			lastDirectiveWasAgent = true
+		case "allow", "disallow":
+			if len(currentAgents) == 0 {
+				ignore() // Rule without a user-agent
+				continue
+			}
+			lastDirectiveWasAgent = false
+			if PathToken(value) == "" {
+				if directive == "allow" {
+					ignore()
+					continue
+				}
+				// An empty Disallow allows everything. The agent is kept
+				// without rules, rather than given a root path that would read
+				// as disallowing everything.
+				for _, agent := range currentAgents {
+					if _, ok := allRules[agent]; !ok {
+						allRules[agent] = make(RuleSet)
+					}
+				}
+				continue
+			}
+			// Use the raw path from the file, but create a full URL for comparison
+			// Note: The diff logic relies on paths being consistent.
+			// Using the merged URL path ensures "path" and "/path" are treated same.
+			fullPath, err := MergeURLPath(u, PathToken(value))
+			if err != nil {
+				ignore()
+				continue
+			}
+			for _, agent := range currentAgents {
+				if _, ok := allRules[agent]; !ok {
+					allRules[agent] = make(RuleSet)
+				}
+				// Store the full path for consistent diffing
+				allRules[agent][fullPath] = directive
+				order[agent] = append(order[agent], OrderedRule{Directive: directive, Path: fullPath})
+			}
+		case "crawl-delay":
+			delay := PathToken(value)
+			if len(currentAgents) == 0 || delay == "" {
+				ignore()
+				continue
+			}
+			for _, agent := range currentAgents {
+				delays[agent] = delay
+			}
+			lastDirectiveWasAgent = false
+		case "sitemap":
+			// Sitemaps aren't part of any group, so they don't end one
+			sitemaps = append(sitemaps, value)
+		default:
+			// Any other directive also breaks an agent group.
+			ignore()
+			lastDirectiveWasAgent = false
+		}
+	}
+	return ParsedRobots{Rules: allRules, Order: order, Delays: delays, Sitemaps: sitemaps, Ignored: ignored}
+}
+
+// ExtractPaths returns every Allow/Disallow path and Sitemap of a robots.txt
+// version found at timestamp. Rules are reported once per agent of their
+// group, like Parse groups them, but rules outside of any group are kept too.
+func ExtractPaths(body []byte, u string, timestamp string) ([]Path, error) {
+	paths := make([]Path, 0)
+
+	var currentAgents []string
+	lastDirectiveWasAgent := false
+	names := make(agentNames)
+
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(body, []byte(bom))))
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		directive, value, ok := SplitDirective(scanner.Text())
+		if ok && directive == "user-agent" {
+			if value == "" {
+				continue
+			}
+			if !lastDirectiveWasAgent {
+				currentAgents = nil
+			}
+			currentAgents = append(currentAgents, names.canonical(value))
+			lastDirectiveWasAgent = true
+			continue
+		}
+		if ok && directive != "sitemap" {
+			lastDirectiveWasAgent = false
+		}
+		if ok && directive == "sitemap" {
+			if sitemap, err := SitemapURL(u, value); err == nil {
+				paths = append(paths, Path{URL: sitemap, Directive: "sitemap", Timestamp: timestamp})
+			}
+			continue
+		}
+		if !ok || (directive != "allow" && directive != "disallow") {
+			continue
+		}
+		path := PathToken(value)
+		if path != "" {
+			fullURL, err := MergeURLPath(u, path)
+			if err != nil {
+				continue
+			}
+			if len(currentAgents) == 0 {
+				paths = append(paths, Path{URL: fullURL, Directive: directive, Timestamp: timestamp})
+			}
+			for _, agent := range currentAgents {
+				paths = append(paths, Path{URL: fullURL, Directive: directive, Agent: agent, Timestamp: timestamp})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// stripComment cuts a line at its first "#" that isn't escaped as "\#". An
+// escaped "#" is part of the path, so it is percent-encoded rather than left
+// to start a URL fragment.
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		if line[i] == '#' {
+			return strings.ReplaceAll(line[:i], `\#`, "%23")
+		}
+		if line[i] == '\\' && i+1 < len(line) && line[i+1] == '#' {
+			i++
+		}
+	}
+	return strings.ReplaceAll(line, `\#`, "%23")
+}
+
+// agentNames maps every user-agent of a file, lowercased, to the spelling it
+// first appeared with. Agents match case-insensitively, so groups of
+// "Googlebot" and "googlebot" are one agent and their rules are merged, like
+// repeated groups of the same agent.
+type agentNames map[string]string
+
+func (n agentNames) canonical(agent string) string {
+	key := strings.ToLower(agent)
+	if name, ok := n[key]; ok {
+		return name
+	}
+	n[key] = agent
+	return agent
+}
+
+// bom is the UTF-8 byte order mark some editors put at the start of a file.
+// Left in place, it would be part of the first directive.
+const bom = "\ufeff"
+
+// scanLines is bufio.ScanLines, except that a lone "\r" also ends a line, as
+// in files saved with old Mac line endings. "\r\n" is still a single break.
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil // Need more data to tell "\r" from "\r\n"
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// DiffRuleSets compares the rules of one user-agent across two versions. A
+// path that switched directive is both added under its new directive and
+// removed under its old one.
+func DiffRuleSets(current, previous RuleSet) (addedAllows, removedAllows, addedDisallows, removedDisallows []string) {
+	for path, directive := range current {
+		prevDirective, exists := previous[path]
+		if !exists { // Path is new
+			if directive == "allow" {
+				addedAllows = append(addedAllows, path)
+			} else {
+				addedDisallows = append(addedDisallows, path)
+			}
+		} else if directive != prevDirective { // Path changed directive
+			if directive == "allow" { // Was disallow, now allow
+				addedAllows = append(addedAllows, path)
+				removedDisallows = append(removedDisallows, path)
+			} else { // Was allow, now disallow
+				addedDisallows = append(addedDisallows, path)
+				removedAllows = append(removedAllows, path)
+			}
+		}
+	}
+
+	for path, prevDirective := range previous {
+		if _, exists := current[path]; !exists { // Path was removed
+			if prevDirective == "allow" {
+				removedAllows = append(removedAllows, path)
+			} else {
+				removedDisallows = append(removedDisallows, path)
+			}
+		}
+	}
+	sort.Strings(addedAllows)
+	sort.Strings(removedAllows)
+	sort.Strings(addedDisallows)
+	sort.Strings(removedDisallows)
+	return
+}
+
+// IsHTMLPage reports whether body looks like an HTML page, such as the Wayback
+// calendar or an error page, rather than a plain-text robots.txt.
+func IsHTMLPage(body []byte) bool {
+	start := bytes.ToLower(bytes.TrimSpace(body))
+	if len(start) > 512 {
+		start = start[:512]
+	}
+	return bytes.HasPrefix(start, []byte("<!doctype html")) ||
+		bytes.HasPrefix(start, []byte("<html")) ||
+		bytes.Contains(start, []byte("<head>"))
+}
+
+// SplitDirective splits a robots.txt line into its lowercased directive and
+// trimmed value, without any trailing comment. Comments, blank lines and lines
+// without a colon are rejected.
+func SplitDirective(line string) (directive, value string, ok bool) {
+	line = strings.TrimSpace(stripComment(line))
+	if strings.HasPrefix(line, "#") || line == "" {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(line, ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1]), true
+}
+
+// PathToken returns the first whitespace-delimited token of an Allow/Disallow
+// value, dropping any tab-separated junk that follows the path.
+func PathToken(value string) string {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// SitemapURL resolves the value of a Sitemap directive, which should be an
+// absolute URL but is sometimes a path on the site itself.
+func SitemapURL(baseURL, value string) (string, error) {
+	value = PathToken(value)
+	if value == "" {
+		return "", fmt.Errorf("empty sitemap")
+	}
+	if strings.Contains(value, "://") {
+		return value, nil
+	}
+	return MergeURLPath(baseURL, value)
+}
+
+// SitemapURLs resolves the Sitemap values of a version with SitemapURL and
+// returns them sorted and without duplicates. Values that don't resolve are
+// dropped.
+func SitemapURLs(baseURL string, values []string) []string {
+	seen := make(map[string]bool)
+	sitemaps := []string{}
+	for _, value := range values {
+		sitemap, err := SitemapURL(baseURL, value)
+		if err != nil || seen[sitemap] {
+			continue
+		}
+		seen[sitemap] = true
+		sitemaps = append(sitemaps, sitemap)
+	}
+	sort.Strings(sitemaps)
+	return sitemaps
+}
+
+// MergeURLPath resolves a robots.txt path against the base URL of the site.
+func MergeURLPath(baseURL, path string) (string, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	// Use ResolveReference to correctly handle paths
+	pathURL, err := url.Parse(path)
+	if err != nil {
+		return "", err
+	}
+
+	resolvedURL := base.ResolveReference(pathURL)
+	return resolvedURL.String(), nil
+}
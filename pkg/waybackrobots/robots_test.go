@@ -0,0 +1,200 @@
+package waybackrobots
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testBase = "https://example.com"
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    AgentRules
+	}{
+		{
+			name:    "stacked user-agents share rules",
+			content: "User-agent: a\nUser-agent: b\nDisallow: /ab\n",
+			want: AgentRules{
+				"a": {testBase + "/ab": "disallow"},
+				"b": {testBase + "/ab": "disallow"},
+			},
+		},
+		{
+			name:    "wildcard group followed by a specific group",
+			content: "User-agent: *\nDisallow: /star\n\nUser-agent: Googlebot\nDisallow: /google\n",
+			want: AgentRules{
+				"*":         {testBase + "/star": "disallow"},
+				"Googlebot": {testBase + "/google": "disallow"},
+			},
+		},
+		{
+			name:    "repeated group of the same agent is merged",
+			content: "User-agent: Googlebot\nDisallow: /one\n\nUser-agent: *\nDisallow: /star\n\nUser-agent: Googlebot\nAllow: /two\n",
+			want: AgentRules{
+				"Googlebot": {testBase + "/one": "disallow", testBase + "/two": "allow"},
+				"*":         {testBase + "/star": "disallow"},
+			},
+		},
+		{
+			name:    "bare user-agent neither starts nor ends a group",
+			content: "User-agent: *\nDisallow: /star\nUser-agent:\nDisallow: /after-bare\n",
+			want: AgentRules{
+				"*": {testBase + "/star": "disallow", testBase + "/after-bare": "disallow"},
+			},
+		},
+		{
+			name:    "sitemap inside a group doesn't end it",
+			content: "User-agent: Googlebot\nSitemap: /sitemap.xml\nUser-agent: Bingbot\nDisallow: /bots\n",
+			want: AgentRules{
+				"Googlebot": {testBase + "/bots": "disallow"},
+				"Bingbot":   {testBase + "/bots": "disallow"},
+			},
+		},
+		{
+			name:    "agent names match case-insensitively",
+			content: "User-agent: Googlebot\nDisallow: /one\n\nUser-agent: googlebot\nDisallow: /two\n",
+			want: AgentRules{
+				"Googlebot": {testBase + "/one": "disallow", testBase + "/two": "disallow"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(tt.content, testBase).Rules
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q).Rules = %v, want %v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLineEndings(t *testing.T) {
+	want := AgentRules{
+		"*": {testBase + "/a": "disallow", testBase + "/b": "allow"},
+	}
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"LF", "User-agent: *\nDisallow: /a\nAllow: /b\n"},
+		{"BOM and CRLF", "\ufeffUser-agent: *\r\nDisallow: /a\r\nAllow: /b\r\n"},
+		{"bare CR", "User-agent: *\rDisallow: /a\rAllow: /b\r"},
+		{"BOM and mixed endings", "\ufeffUser-agent: *\rDisallow: /a\r\nAllow: /b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed := Parse(tt.content, testBase)
+			if !reflect.DeepEqual(parsed.Rules, want) {
+				t.Errorf("Parse(%q).Rules = %v, want %v", tt.content, parsed.Rules, want)
+			}
+			if len(parsed.Ignored) > 0 {
+				t.Errorf("Parse(%q).Ignored = %v, want none", tt.content, parsed.Ignored)
+			}
+
+			paths, err := ExtractPaths([]byte(tt.content), testBase, "20200101000000")
+			if err != nil {
+				t.Fatalf("ExtractPaths(%q) failed: %v", tt.content, err)
+			}
+			got := make(map[string]string)
+			for _, path := range paths {
+				if path.Agent != "*" {
+					t.Errorf("ExtractPaths(%q) attributed %s to agent %q, want \"*\"", tt.content, path.URL, path.Agent)
+				}
+				got[path.URL] = path.Directive
+			}
+			if !reflect.DeepEqual(got, map[string]string(want["*"])) {
+				t.Errorf("ExtractPaths(%q) = %v, want %v", tt.content, got, want["*"])
+			}
+		})
+	}
+}
+
+func TestSplitDirectiveComments(t *testing.T) {
+	tests := []struct {
+		line          string
+		wantDirective string
+		wantValue     string
+		wantOK        bool
+	}{
+		{"Disallow: /a", "disallow", "/a", true},
+		{"Disallow: /a # x", "disallow", "/a", true},
+		{"Disallow: /a#frag", "disallow", "/a", true},
+		{`Disallow: /a\#b`, "disallow", "/a%23b", true},
+		{`Disallow: /a\#b # x`, "disallow", "/a%23b", true},
+		{"User-agent: Googlebot # the main crawler", "user-agent", "Googlebot", true},
+		{"Disallow: # nothing", "disallow", "", true},
+		{"# Disallow: /a", "", "", false},
+		{"   # indented comment", "", "", false},
+	}
+
+	for _, tt := range tests {
+		directive, value, ok := SplitDirective(tt.line)
+		if directive != tt.wantDirective || value != tt.wantValue || ok != tt.wantOK {
+			t.Errorf("SplitDirective(%q) = %q, %q, %v, want %q, %q, %v", tt.line, directive, value, ok, tt.wantDirective, tt.wantValue, tt.wantOK)
+		}
+	}
+}
+
+func TestParseTrailingComments(t *testing.T) {
+	content := "User-agent: * # everyone\nDisallow: /private # internal only\nDisallow: /a#frag\nAllow: /public\n# Disallow: /commented-out\n"
+	want := AgentRules{
+		"*": {
+			testBase + "/private": "disallow",
+			testBase + "/a":       "disallow",
+			testBase + "/public":  "allow",
+		},
+	}
+	if got := Parse(content, testBase).Rules; !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse(%q).Rules = %v, want %v", content, got, want)
+	}
+}
+
+func TestTabLadenValues(t *testing.T) {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "tabs.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantRules := AgentRules{
+		"*": {
+			testBase + "/admin":      "disallow",
+			testBase + "/search":     "disallow",
+			testBase + "/public":     "allow",
+			testBase + "/tabbed-end": "disallow",
+			testBase + "/indented":   "disallow",
+		},
+	}
+	parsed := Parse(string(content), testBase)
+	if !reflect.DeepEqual(parsed.Rules, wantRules) {
+		t.Errorf("Parse(tabs.txt).Rules = %v, want %v", parsed.Rules, wantRules)
+	}
+	if got := parsed.Delays["*"]; got != "5" {
+		t.Errorf("Parse(tabs.txt).Delays[\"*\"] = %q, want \"5\"", got)
+	}
+
+	paths, err := ExtractPaths(content, testBase, "20200101000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, path := range paths {
+		got = append(got, path.Directive+" "+path.URL)
+	}
+	want := []string{
+		"disallow " + testBase + "/admin",
+		"disallow " + testBase + "/search",
+		"allow " + testBase + "/public",
+		"disallow " + testBase + "/tabbed-end",
+		"disallow " + testBase + "/indented",
+		"sitemap " + testBase + "/sitemap.xml",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractPaths(tabs.txt) = %q, want %q", got, want)
+	}
+}
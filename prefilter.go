@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mhmdiaa/waybackrobots/pkg/waybackrobots"
+)
+
+// hasCaptures reports whether CDX has at least one successful robots.txt
+// capture for u, looking at the same hosts, schemes and match type as the
+// crawl would. It asks for a single row per target, so it is much cheaper than
+// a full GetRobotsTxtVersions query.
+func hasCaptures(ctx context.Context, u string, opts options) (bool, error) {
+	if opts.mergeWWW {
+		u = apexURL(u)
+	}
+	if opts.scheme == "http" || opts.scheme == "https" {
+		u = withScheme(u, opts.scheme)
+	}
+	targets := []string{u}
+	if opts.mergeWWW {
+		targets = append(targets, wwwURL(u))
+	}
+	if opts.scheme == "both" {
+		for _, target := range targets {
+			if strings.HasPrefix(target, "http://") {
+				targets = append(targets, withScheme(target, "https"))
+			} else {
+				targets = append(targets, withScheme(target, "http"))
+			}
+		}
+	}
+
+	for _, target := range targets {
+		found, err := targetHasCaptures(ctx, target, opts.match)
+		if found || err != nil {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// targetHasCaptures reports whether the CDX query of target's robots.txt
+// returns at least one row.
+func targetHasCaptures(ctx context.Context, target, matchType string) (bool, error) {
+	scope := waybackrobots.CDXScope(target, matchType)
+	requestURL := fmt.Sprintf("https://web.archive.org/cdx/search/cdx?%s&output=json&fl=timestamp&filter=statuscode:200&limit=1", scope)
+	raw, err := fetchCDX(ctx, requestURL)
+	if err != nil {
+		return false, err
+	}
+	cdxDump.dump(requestURL, raw)
+
+	var rows [][]string
+	if err := json.Unmarshal(raw, &rows); err != nil {
+		return false, err
+	}
+	return len(rows) > 1, nil // The first row is the header
+}
+
+// prefilterURLs keeps only the inputs with archived robots.txt history,
+// checking up to concurrency inputs at a time. Inputs that can't be checked
+// are kept, so a CDX hiccup doesn't drop a domain. Input order is preserved.
+func prefilterURLs(ctx context.Context, urls []string, concurrency int, opts options) []string {
+	keep := make([]bool, len(urls))
+	jobs := make(chan int, len(urls))
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				u, err := cleanURL(urls[i])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error cleaning URL %s: %v\n", urls[i], err)
+					keep[i] = true
+					continue
+				}
+				found, err := hasCaptures(ctx, u, opts)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error checking captures for %s, keeping it: %v\n", u, err)
+					keep[i] = true
+					continue
+				}
+				keep[i] = found
+			}
+		}()
+	}
+
+	for i := range urls {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	filtered := make([]string, 0, len(urls))
+	for i, rawURL := range urls {
+		if keep[i] {
+			filtered = append(filtered, rawURL)
+		}
+	}
+	infof("Prefilter kept %d of %d domains with archived robots.txt\n", len(filtered), len(urls))
+	return filtered
+}
+
+// writeURLList writes one URL per line to filePath.
+func writeURLList(filePath string, urls []string) error {
+	content := strings.Join(urls, "\n")
+	if len(urls) > 0 {
+		content += "\n"
+	}
+	return ioutil.WriteFile(filePath, []byte(content), 0644)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rawDiffContext is the number of unchanged lines shown around each change.
+const rawDiffContext = 3
+
+// maxDiffEdits caps the edit distance diffLines searches for. The trace it
+// keeps grows with its square, so two unrelated large files are shown as one
+// replaced block instead.
+const maxDiffEdits = 1000
+
+// rawDiffTracker collects a unified diff of the raw content of every version
+// against its predecessor, for -raw-diff. Unlike the rule diff, it also shows
+// edited comments, reordered lines and directives the parser ignores.
+// Versions must be added in timestamp order.
+//
+// With -merge-www or -scheme both, the versions of different files alternate,
+// so each is diffed against the previous version of its own file only.
+type rawDiffTracker struct {
+	u        string
+	previous map[string]VersionContent // Key: base URL the file was captured under
+	diff     strings.Builder
+}
+
+// newRawDiffTracker starts from the baseline versions a previous run left off
+// at, if any.
+func newRawDiffTracker(u string, baseline []VersionContent) *rawDiffTracker {
+	t := &rawDiffTracker{u: u, previous: make(map[string]VersionContent)}
+	for _, vc := range baseline {
+		t.previous[vc.URL] = vc
+	}
+	return t
+}
+
+// add diffs vc against the previous version captured under base, which is the
+// URL of vc before it was merged.
+func (t *rawDiffTracker) add(base string, vc VersionContent) {
+	name := "robots.txt"
+	if base != t.u {
+		name = base + "/robots.txt"
+	}
+
+	from := "/dev/null"
+	previous, ok := t.previous[base]
+	if ok {
+		from = name + "@" + previous.Timestamp
+	}
+	t.diff.WriteString(unifiedDiff(from, name+"@"+vc.Timestamp, previous.RawContent, vc.RawContent))
+	t.previous[base] = VersionContent{Timestamp: vc.Timestamp, RawContent: vc.RawContent}
+}
+
+// write prints the diff to stdout, or writes raw_diff.patch next to the
+// timeline output.
+func (t *rawDiffTracker) write(u string, opts options) {
+	if opts.outputDir == "" {
+		fmt.Print(t.diff.String())
+		return
+	}
+
+	dirPath := timelineDir(u, opts)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", dirPath, err)
+		return
+	}
+	filePath := filepath.Join(dirPath, "raw_diff.patch")
+	if err := writeFileAtomic(filePath, []byte(t.diff.String())); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing raw diff to %s: %v\n", filePath, err)
+	} else {
+		infof("Wrote raw diff to %s\n", filePath)
+	}
+}
+
+// diffOp is a line of a diff: ' ' when unchanged, '-' when removed from the
+// old content or '+' when added in the new one.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns the unified diff between two versions of a file, or an
+// empty string when their lines are the same.
+func unifiedDiff(fromName, toName, from, to string) string {
+	ops := diffLines(splitLines(from), splitLines(to))
+
+	// Line numbers of the old and new content before each op
+	fromLine := make([]int, len(ops)+1)
+	toLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		fromLine[i+1], toLine[i+1] = fromLine[i], toLine[i]
+		if op.kind != '+' {
+			fromLine[i+1]++
+		}
+		if op.kind != '-' {
+			toLine[i+1]++
+		}
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		// Changes closer than twice the context share a hunk
+		start := i - rawDiffContext
+		if start < 0 {
+			start = 0
+		}
+		lastChange := i
+		for end := i; end < len(ops); end++ {
+			if ops[end].kind != ' ' {
+				lastChange = end
+			} else if end-lastChange > 2*rawDiffContext {
+				break
+			}
+		}
+		stop := lastChange + rawDiffContext + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(fromLine[start], fromLine[stop]), hunkRange(toLine[start], toLine[stop]))
+		for _, op := range ops[start:stop] {
+			fmt.Fprintf(&out, "%c%s\n", op.kind, op.line)
+		}
+		i = stop
+	}
+	return out.String()
+}
+
+// hunkRange formats the lines after first up to last as "start,count". An
+// empty range starts at the line it follows, as diff(1) does.
+func hunkRange(first, last int) string {
+	count := last - first
+	if count == 0 {
+		return fmt.Sprintf("%d,0", first)
+	}
+	return fmt.Sprintf("%d,%d", first+1, count)
+}
+
+// splitLines splits content into lines without their line endings.
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script turning a into b, computed with
+// Myers' algorithm so files of thousands of lines with few changes stay cheap.
+// Past maxDiffEdits edits, it falls back to replaceLines.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int // Diagonals -d-1 to d+1 of v before step d
+
+search:
+	for d := 0; d <= max; d++ {
+		if d > maxDiffEdits {
+			return replaceLines(a, b)
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Down: insertion
+			} else {
+				x = v[offset+k-1] + 1 // Right: deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk the trace back from the end to recover the edits
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v, offset := trace[d], d+1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{kind: ' ', line: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{kind: '+', line: b[prevY]})
+			} else {
+				ops = append(ops, diffOp{kind: '-', line: a[prevX]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// replaceLines is the edit script that keeps the lines a and b start and end
+// with and replaces everything in between.
+func replaceLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b)-prefix-suffix)
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{kind: ' ', line: line})
+	}
+	for _, line := range a[prefix : len(a)-suffix] {
+		ops = append(ops, diffOp{kind: '-', line: line})
+	}
+	for _, line := range b[prefix : len(b)-suffix] {
+		ops = append(ops, diffOp{kind: '+', line: line})
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{kind: ' ', line: line})
+	}
+	return ops
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// sharedCluster is a group of domains whose latest robots.txt is identical.
+type sharedCluster struct {
+	SHA256  string   `json:"sha256"`
+	Domains []string `json:"domains"`
+}
+
+// findSharedRobots fetches the latest robots.txt of every input, checking up
+// to concurrency inputs at a time, and reports the groups of domains serving
+// byte-identical content, which hints at shared infrastructure or templates.
+func findSharedRobots(ctx context.Context, urls []string, concurrency int, outputDir string) {
+	bar := newProgressBar(int64(len(urls)), "Fetching latest robots.txt versions...")
+
+	var mu sync.Mutex
+	byHash := make(map[string][]string)
+	jobs := make(chan string, len(urls))
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rawURL := range jobs {
+				u, vc, err := latestVersion(ctx, rawURL, bar)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error fetching latest robots.txt for %s: %v\n", rawURL, err)
+					continue
+				}
+				sum := sha256.Sum256([]byte(vc.RawContent))
+				hash := hex.EncodeToString(sum[:])
+
+				mu.Lock()
+				byHash[hash] = append(byHash[hash], getHost(u))
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, u := range urls {
+		jobs <- u
+	}
+	close(jobs)
+	wg.Wait()
+
+	clusters := make([]sharedCluster, 0)
+	for hash, domains := range byHash {
+		if len(domains) < 2 {
+			continue
+		}
+		sort.Strings(domains)
+		clusters = append(clusters, sharedCluster{SHA256: hash, Domains: domains})
+	}
+	// Largest clusters first
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i].Domains) != len(clusters[j].Domains) {
+			return len(clusters[i].Domains) > len(clusters[j].Domains)
+		}
+		return clusters[i].Domains[0] < clusters[j].Domains[0]
+	})
+
+	if outputDir == "" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(clusters); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing JSON: %v\n", err)
+		}
+		return
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", outputDir, err)
+		return
+	}
+	filePath := filepath.Join(outputDir, "shared_robots.json")
+	if err := writeJSONFile(filePath, clusters); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing JSON to %s: %v\n", filePath, err)
+	} else {
+		infof("Wrote %d shared robots.txt clusters to %s\n", len(clusters), filePath)
+	}
+}
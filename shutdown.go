@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// handleShutdown returns a context that is cancelled on the first SIGINT or
+// SIGTERM. In-flight requests are aborted and workers skip their remaining
+// jobs, so what was collected so far still gets written. A second signal
+// exits immediately.
+func handleShutdown() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Fprintln(os.Stderr, "\nInterrupted, writing the results collected so far. Interrupt again to quit immediately.")
+		cancel()
+		<-signals
+		os.Exit(130)
+	}()
+	return ctx
+}
+
+// sleepInterruptible sleeps for d and reports whether it did so without ctx
+// being cancelled.
+func sleepInterruptible(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// runState records, per domain, the latest snapshot timestamp that has been
+// fetched so that later runs only need to look at newer captures, and the
+// captures those runs diff against.
+type runState struct {
+	mu        sync.Mutex
+	path      string
+	Latest    map[string]string         `json:"latest"`              // Key: domain, Value: CDX timestamp
+	Baselines map[string][]stateCapture `json:"baselines,omitempty"` // Key: domain
+}
+
+// stateCapture is the latest capture of one host of a domain, as fetched by
+// the previous run. With -merge-www there is one for each host, so the
+// baseline can be merged the same way the versions after it are.
+type stateCapture struct {
+	Timestamp string `json:"timestamp"`
+	URL       string `json:"url"` // Base URL the capture was made under
+	Original  string `json:"original,omitempty"`
+}
+
+// loadState reads the state file at path. A missing file yields an empty state.
+func loadState(path string) (*runState, error) {
+	state := &runState{
+		path:      path,
+		Latest:    make(map[string]string),
+		Baselines: make(map[string][]stateCapture),
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, state); err != nil {
+		return nil, err
+	}
+	if state.Latest == nil {
+		state.Latest = make(map[string]string)
+	}
+	if state.Baselines == nil {
+		state.Baselines = make(map[string][]stateCapture)
+	}
+	return state, nil
+}
+
+func (s *runState) get(domain string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Latest[domain]
+}
+
+// baseline returns the captures recorded for domain, oldest first. State files
+// written before captures were recorded have none.
+func (s *runState) baseline(domain string) []stateCapture {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Baselines[domain]
+}
+
+// update records the latest captures of domain if they are newer than the
+// stored ones and saves the state file right away so an interrupted run
+// doesn't lose progress. captures must be sorted by timestamp.
+func (s *runState) update(domain string, captures []stateCapture) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(captures) == 0 {
+		return nil
+	}
+	timestamp := captures[len(captures)-1].Timestamp
+	if timestamp <= s.Latest[domain] {
+		return nil
+	}
+	s.Latest[domain] = timestamp
+	s.Baselines[domain] = captures
+
+	raw, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated state
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+// nextTimestamp returns the CDX timestamp one second after timestamp.
+func nextTimestamp(timestamp string) string {
+	t, err := time.Parse("20060102150405", timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return t.Add(time.Second).Format("20060102150405")
+}
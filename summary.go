@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// stats accumulates counters across every domain processed in this run.
+var stats runStats
+
+type runStats struct {
+	domains   atomic.Int64
+	paths     atomic.Int64
+	snapshots atomic.Int64
+	failures  atomic.Int64
+	noRobots  atomic.Int64 // Domains without a single archived robots.txt
+}
+
+// writeRunSummary writes run_summary.json with the run's totals and the
+// effective value of every flag.
+func writeRunSummary(outputDir string, started time.Time) {
+	summary := struct {
+		Domains         int64             `json:"domains"`
+		NoRobots        int64             `json:"domains_without_robots_txt"`
+		UniquePaths     int64             `json:"unique_paths"`
+		Snapshots       int64             `json:"snapshots_fetched"`
+		Failures        int64             `json:"failures"`
+		Started         string            `json:"started"`
+		DurationSeconds float64           `json:"duration_seconds"`
+		Settings        map[string]string `json:"settings"`
+	}{
+		Domains:         stats.domains.Load(),
+		NoRobots:        stats.noRobots.Load(),
+		UniquePaths:     stats.paths.Load(),
+		Snapshots:       stats.snapshots.Load(),
+		Failures:        stats.failures.Load(),
+		Started:         started.UTC().Format(time.RFC3339),
+		DurationSeconds: time.Since(started).Seconds(),
+		Settings:        flagSettings(),
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", outputDir, err)
+		return
+	}
+
+	filePath := filepath.Join(outputDir, "run_summary.json")
+	if err := writeJSONFile(filePath, summary); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing JSON to %s: %v\n", filePath, err)
+	} else {
+		infof("Wrote run summary to %s\n", filePath)
+	}
+}
+
+// flagSettings returns the effective value of every flag, keyed by name.
+func flagSettings() map[string]string {
+	settings := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		settings[f.Name] = f.Value.String()
+	})
+	return settings
+}
+
+// domainTable collects a row per domain for -summary-table, nil when the
+// table is disabled.
+var domainTable *summaryTable
+
+type summaryRow struct {
+	domain   string
+	versions int
+	paths    int
+	first    string
+	last     string
+}
+
+type summaryTable struct {
+	mu   sync.Mutex
+	rows []summaryRow
+}
+
+// add records the result of a domain. It is a no-op on a nil table.
+func (t *summaryTable) add(domain string, versions []snapshot, paths int) {
+	if t == nil {
+		return
+	}
+	row := summaryRow{domain: domain, versions: len(versions), paths: paths}
+	for _, version := range versions {
+		if row.first == "" || version.Timestamp < row.first {
+			row.first = version.Timestamp
+		}
+		if version.Timestamp > row.last {
+			row.last = version.Timestamp
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rows = append(t.rows, row)
+}
+
+// render prints the table aligned to stderr, sorted by domain.
+func (t *summaryTable) render() {
+	if t == nil || len(t.rows) == 0 {
+		return
+	}
+	sort.Slice(t.rows, func(i, j int) bool {
+		return t.rows[i].domain < t.rows[j].domain
+	})
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DOMAIN\tVERSIONS\tPATHS\tFIRST\tLAST")
+	for _, row := range t.rows {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", row.domain, row.versions, row.paths, formatDay(row.first), formatDay(row.last))
+	}
+	w.Flush()
+}
+
+// formatDay turns a CDX timestamp into YYYY-MM-DD, or "-" when there is none.
+func formatDay(timestamp string) string {
+	if len(timestamp) < 8 {
+		return "-"
+	}
+	return timestamp[:4] + "-" + timestamp[4:6] + "-" + timestamp[6:8]
+}
@@ -0,0 +1,835 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhmdiaa/waybackrobots/pkg/waybackrobots"
+	"github.com/schollz/progressbar/v3"
+)
+
+// createTimeline fetches the selected versions of u and reports how its
+// robots.txt changed between them. Versions are processed one at a time in
+// timestamp order and their raw content is dropped once it has been diffed
+// (and saved, with -output), so memory doesn't grow with the length of the
+// history.
+func createTimeline(ctx context.Context, u string, opts options) {
+	// In incremental mode, only look at captures newer than the last run
+	var lastTimestamp, since string
+	if opts.state != nil {
+		lastTimestamp = opts.state.get(getHost(u))
+		if lastTimestamp != "" {
+			since = nextTimestamp(lastTimestamp)
+		}
+	}
+
+	versions, err := getSnapshots(ctx, u, opts, opts.year, since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting versions: %v\n", err)
+		return
+	}
+	if len(versions) == 0 {
+		if since != "" {
+			infof("No new versions found for %s since %s\n", u, lastTimestamp)
+			return
+		}
+		stats.noRobots.Add(1)
+		if opts.year > 0 {
+			infof("No archived robots.txt found for %s in %s\n", u, yearLabel(opts))
+		} else {
+			infof("No archived robots.txt found for %s\n", u)
+		}
+		return
+	}
+
+	progressbarMessage := fmt.Sprintf("Fetching %s/robots.txt versions for timeline...", u)
+	bar := newProgressBar(int64(len(versions)), progressbarMessage)
+
+	var merger *hostMerger
+	if opts.mergeWWW {
+		merger = newHostMerger(u)
+	}
+
+	// The last version seen by the previous run is the baseline new changes
+	// are diffed against, so it isn't reported as initial content again. It
+	// goes through the same merging and filtering as the versions after it.
+	var baseline AgentRules
+	var baselineDelays AgentDelays
+	var baselineSitemaps []string
+	var baselineVersions []VersionContent
+	var baselineCaptures []stateCapture
+	if lastTimestamp != "" {
+		captures := opts.state.baseline(getHost(u))
+		if len(captures) == 0 {
+			// Recorded before the state kept the captures themselves
+			captures = []stateCapture{{Timestamp: lastTimestamp, URL: u}}
+		}
+		bar.ChangeMax(len(versions) + len(captures))
+		var merged VersionContent
+		for _, capture := range captures {
+			s := snapshot{Timestamp: capture.Timestamp, URL: capture.URL, Original: capture.Original}
+			parsed, rawContent, _ := fetchRules(ctx, s.Timestamp, s.URL, s.Original, bar)
+			if parsed.Rules == nil {
+				// A failed baseline stays nil, so the first version is initial content
+				baselineVersions = nil
+				break
+			}
+			vc := newVersionContent(s, parsed, rawContent, opts)
+			baselineVersions = append(baselineVersions, vc)
+			if merger != nil {
+				vc = merger.merge(vc)
+			}
+			merged = onlyAgents(vc, opts.agents)
+		}
+		if baselineVersions != nil {
+			baselineCaptures = captures
+			baseline, baselineDelays, baselineSitemaps = merged.Rules, merged.Delays, merged.Sitemaps
+		} else if merger != nil {
+			merger = newHostMerger(u)
+		}
+	}
+
+	// Without -output only one report fits on stdout: -format dot, then the
+	// first JSON report requested, then the text timeline.
+	toStdout := opts.outputDir == ""
+	dot := opts.format == "dot"
+	lifespan := opts.pathLifespan && !dot
+	flipped := opts.flipped && !dot && !(toStdout && lifespan)
+	ordered := opts.ordered && !dot && !(toStdout && (lifespan || flipped))
+	counts := opts.ruleCounts && !dot && !(toStdout && (lifespan || flipped || ordered))
+	rawDiff := opts.rawDiff && !dot && !(toStdout && (lifespan || flipped || ordered || counts))
+	text := toStdout && !dot && !lifespan && !flipped && !ordered && !counts && !rawDiff
+
+	var (
+		lifespans      *lifespanTracker
+		flips          *flipTracker
+		orderedRules   []orderedVersion
+		ruleCounts     []ruleCount
+		rawDiffs       *rawDiffTracker
+		dotVersion     VersionContent
+		haveDotVersion bool
+		file           *timelineFile
+		entries        *textTimeline
+	)
+	if lifespan {
+		lifespans = newLifespanTracker()
+	}
+	if flipped {
+		flips = newFlipTracker()
+	}
+	if rawDiff {
+		rawDiffs = newRawDiffTracker(u, baselineVersions)
+	}
+	if !toStdout && !dot {
+		file, err = newTimelineFile(u, baseline, baselineDelays, baselineSitemaps, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error preparing timeline output for %s: %v\n", u, err)
+			return
+		}
+	}
+	if text {
+		entries = &textTimeline{previousRules: baseline, previousDelays: baselineDelays, previousSitemaps: baselineSitemaps, flagAgents: opts.flagAgents}
+	}
+
+	usable := 0
+	uniquePaths := make(map[string]bool)
+	dbPrevious := baseline
+	var dbChanges []dbChange
+	var lastKept time.Time
+	if baseline != nil {
+		lastKept, _ = time.Parse("20060102150405", lastTimestamp)
+	}
+	complete := fetchInOrder(ctx, versions, opts, bar, func(vc VersionContent) {
+		base := vc.URL
+		if merger != nil {
+			vc = merger.merge(vc)
+		}
+		vc = onlyAgents(vc, opts.agents)
+		usable++
+		if opts.minInterval > 0 {
+			// Keep the earlier of two versions closer than -min-interval
+			t, err := time.Parse("20060102150405", vc.Timestamp)
+			if err == nil && !lastKept.IsZero() && t.Sub(lastKept) < opts.minInterval {
+				return
+			}
+			lastKept = t
+		}
+		for _, rules := range vc.Rules {
+			for path := range rules {
+				uniquePaths[path] = true
+			}
+		}
+
+		if dot && atOrBefore(vc.Timestamp, opts.dotVersion) {
+			dotVersion, haveDotVersion = vc, true
+		}
+		if lifespans != nil {
+			lifespans.add(vc)
+		}
+		if flips != nil {
+			flips.add(vc)
+		}
+		if ordered {
+			orderedRules = append(orderedRules, orderedVersion{Timestamp: vc.Timestamp, Agents: vc.Order})
+		}
+		if counts {
+			ruleCounts = append(ruleCounts, countRules(vc)...)
+		}
+		if rawDiffs != nil {
+			rawDiffs.add(base, vc)
+		}
+		if file != nil {
+			file.add(vc)
+		}
+		if entries != nil {
+			entries.add(vc)
+		}
+		if resultDB != nil {
+			dbChanges = append(dbChanges, timelineChanges(vc, dbPrevious)...)
+			dbPrevious = vc.Rules
+		}
+	})
+
+	if usable == 0 {
+		infof("No usable versions found for %s\n", u)
+		return
+	}
+	stats.paths.Add(int64(len(uniquePaths)))
+	resultDB.writeChanges(getHost(u), dbChanges)
+
+	// The state is only advanced once the output covering it is written, and
+	// never past a snapshot that failed, so the next run fetches it again
+	written := true
+	defer func() {
+		if opts.state == nil || len(complete) == 0 {
+			return
+		}
+		// Hosts without a newer capture keep the one the baseline came from
+		captures := append([]stateCapture(nil), baselineCaptures...)
+		for _, capture := range complete {
+			captures = latestCaptures(captures, capture)
+		}
+		output.submit(func() {
+			if !written {
+				return
+			}
+			if err := opts.state.update(getHost(u), captures); err != nil {
+				fmt.Fprintf(os.Stderr, "Error saving state for %s: %v\n", u, err)
+			}
+		})
+	}()
+
+	if dot {
+		if !haveDotVersion {
+			fmt.Fprintf(os.Stderr, "No version of %s at or before %s\n", u, opts.dotVersion)
+			written = false
+			return
+		}
+		output.submit(func() { writeDot(u, dotVersion, opts) })
+		return
+	}
+	if lifespans != nil {
+		result := lifespans.result()
+		output.submit(func() { writeTimelineJSON(u, "path_lifespan.json", result, opts) })
+	}
+	if flips != nil {
+		result := flips.result()
+		output.submit(func() { writeTimelineJSON(u, "flipped_paths.json", result, opts) })
+	}
+	if ordered {
+		output.submit(func() { writeTimelineJSON(u, "ordered_rules.json", orderedRules, opts) })
+	}
+	if counts {
+		output.submit(func() { writeTimelineJSON(u, "rule_counts.json", ruleCounts, opts) })
+	}
+	if rawDiffs != nil {
+		output.submit(func() { rawDiffs.write(u, opts) })
+	}
+	if file != nil {
+		output.submit(func() { written = file.finish() })
+	}
+	if entries != nil {
+		// The diff needs chronological order, only the output is reversed
+		if opts.reverse {
+			reverseStrings(entries.entries)
+		}
+		output.submit(func() {
+			for _, entry := range entries.entries {
+				fmt.Print(entry)
+			}
+		})
+	}
+}
+
+// fetchInOrder fetches the robots.txt of every snapshot and passes the usable
+// versions to emit in timestamp order. Workers only run a few snapshots ahead
+// of the oldest one not yet emitted, which bounds how many fetched versions
+// are held in memory at once. The timeline is known to be complete up to the
+// first snapshot that couldn't be fetched. fetchInOrder returns the last
+// version of every base URL emitted before it, oldest first, for a later run
+// to diff against.
+func fetchInOrder(ctx context.Context, versions []snapshot, opts options, bar *progressbar.ProgressBar, emit func(VersionContent)) []stateCapture {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Timestamp < versions[j].Timestamp
+	})
+
+	numThreads := opts.threads
+	window := make(chan struct{}, numThreads*2)
+	jobCh := make(chan int)
+	results := make([]chan *VersionContent, len(versions))
+	failed := make([]bool, len(versions)) // Set before the nil result is sent
+	for i := range results {
+		results[i] = make(chan *VersionContent, 1)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(numThreads)
+
+	for i := 0; i < numThreads; i++ {
+		go func() {
+			defer wg.Done()
+			sleepJitter(opts.jitter)
+			for i := range jobCh {
+				if opts.jitterPerRequest {
+					sleepJitter(opts.jitter)
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				version := versions[i]
+				parsed, rawContent, err := fetchRules(ctx, version.Timestamp, version.URL, version.Original, bar)
+				if parsed.Rules == nil {
+					// Failed or unusable snapshot, an empty ruleset would show up
+					// as every rule being removed. Unusable content won't change
+					// on a later run, a failed fetch may.
+					var parseErr *waybackrobots.ParseError
+					failed[i] = err != nil && !errors.As(err, &parseErr)
+					results[i] <- nil
+					continue
+				}
+				vc := newVersionContent(version, parsed, rawContent, opts)
+				results[i] <- &vc
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobCh)
+		for i := range versions {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobCh <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var complete []stateCapture
+	incomplete := false
+	for i := range versions {
+		var vc *VersionContent
+		select {
+		case vc = <-results[i]:
+		case <-ctx.Done():
+		}
+		if vc == nil && ctx.Err() != nil {
+			// Emitting later versions would diff them against the wrong
+			// predecessor, so the timeline ends before the first skipped one
+			break
+		}
+		results[i] = nil
+		<-window
+		if vc == nil {
+			incomplete = incomplete || failed[i]
+			continue
+		}
+		emit(*vc)
+		if !incomplete {
+			complete = latestCaptures(complete, stateCapture{Timestamp: vc.Timestamp, URL: vc.URL, Original: vc.Original})
+		}
+	}
+	wg.Wait()
+	return complete
+}
+
+// latestCaptures adds capture to the latest capture of every base URL,
+// replacing the one of its own URL. Captures must be added oldest first.
+func latestCaptures(captures []stateCapture, capture stateCapture) []stateCapture {
+	kept := captures[:0]
+	for _, c := range captures {
+		if c.URL != capture.URL {
+			kept = append(kept, c)
+		}
+	}
+	return append(kept, capture)
+}
+
+// newVersionContent builds the version of a fetched snapshot, with its paths
+// normalized under -normalize.
+func newVersionContent(s snapshot, parsed ParsedRobots, rawContent string, opts options) VersionContent {
+	if opts.normalize {
+		parsed = normalizeRules(parsed)
+	}
+	return VersionContent{
+		Timestamp:  s.Timestamp,
+		URL:        s.URL,
+		Original:   s.Original,
+		Rules:      parsed.Rules,
+		Order:      parsed.Order,
+		Delays:     parsed.Delays,
+		Sitemaps:   waybackrobots.SitemapURLs(s.URL, parsed.Sitemaps),
+		RawContent: rawContent,
+	}
+}
+
+// textTimeline builds the stdout timeline one version at a time.
+type textTimeline struct {
+	previousRules    AgentRules
+	previousDelays   AgentDelays
+	previousSitemaps []string
+	flagAgents       []string
+	entries          []string
+}
+
+func (t *textTimeline) add(vc VersionContent) {
+	previousRules := t.previousRules
+	t.previousRules = vc.Rules
+	delayChanges := diffDelays(vc.Delays, t.previousDelays)
+	t.previousDelays = vc.Delays
+	addedSitemaps, removedSitemaps := diffSitemaps(vc.Sitemaps, t.previousSitemaps)
+	t.previousSitemaps = vc.Sitemaps
+
+	addedAgents := []string{}
+	removedAgents := []string{}
+	ruleChanges := false
+
+	// Find added/changed agents
+	for agent, currentRules := range vc.Rules {
+		prevAgentRules, exists := previousRules[agent]
+		if !exists {
+			addedAgents = append(addedAgents, agent)
+			ruleChanges = true
+			continue
+		}
+
+		// Check for path changes within the agent
+		addedAllows, removedAllows, addedDisallows, removedDisallows := waybackrobots.DiffRuleSets(currentRules, prevAgentRules)
+		if len(addedAllows) > 0 || len(removedAllows) > 0 || len(addedDisallows) > 0 || len(removedDisallows) > 0 {
+			ruleChanges = true
+		}
+	}
+
+	// Find removed agents
+	for agent := range previousRules {
+		if _, exists := vc.Rules[agent]; !exists {
+			removedAgents = append(removedAgents, agent)
+			ruleChanges = true
+		}
+	}
+
+	sitemapChanges := len(addedSitemaps) > 0 || len(removedSitemaps) > 0
+	if !ruleChanges && !sitemapChanges && len(delayChanges) == 0 && len(addedAgents) == 0 && len(removedAgents) == 0 && previousRules != nil {
+		return // Skip if no changes *and* it's not the first version
+	}
+
+	var entry strings.Builder
+	fmt.Fprintf(&entry, "\n--- Changes on %s ---\n", vc.Timestamp)
+	for _, agent := range flaggedAgents(vc.Rules, previousRules, t.flagAgents) {
+		fmt.Fprintf(&entry, "  [!] Watched User-agent gained rules: %s\n", agent)
+	}
+
+	if previousRules == nil {
+		fmt.Fprintln(&entry, "Initial version:")
+		for agent, rules := range vc.Rules {
+			fmt.Fprintf(&entry, "  User-agent: %s\n", agent)
+			writeAddedRules(&entry, rules)
+		}
+	} else {
+		for _, agent := range addedAgents {
+			fmt.Fprintf(&entry, "  [+] New User-agent: %s\n", agent)
+			writeAddedRules(&entry, vc.Rules[agent])
+		}
+		for _, agent := range removedAgents {
+			fmt.Fprintf(&entry, "  [-] Removed User-agent: %s\n", agent)
+		}
+
+		for agent, currentRules := range vc.Rules {
+			if prevAgentRules, exists := previousRules[agent]; exists {
+				addedAllows, removedAllows, addedDisallows, removedDisallows := waybackrobots.DiffRuleSets(currentRules, prevAgentRules)
+
+				if len(addedAllows) > 0 || len(removedAllows) > 0 || len(addedDisallows) > 0 || len(removedDisallows) > 0 {
+					fmt.Fprintf(&entry, "  [~] Changed User-agent: %s\n", agent)
+					if len(addedAllows) > 0 || len(removedAllows) > 0 {
+						fmt.Fprintln(&entry, "    Allow:")
+						for _, path := range addedAllows {
+							fmt.Fprintf(&entry, "      + %s\n", path)
+						}
+						for _, path := range removedAllows {
+							fmt.Fprintf(&entry, "      - %s\n", path)
+						}
+					}
+					if len(addedDisallows) > 0 || len(removedDisallows) > 0 {
+						fmt.Fprintln(&entry, "    Disallow:")
+						for _, path := range addedDisallows {
+							fmt.Fprintf(&entry, "      + %s\n", path)
+						}
+						for _, path := range removedDisallows {
+							fmt.Fprintf(&entry, "      - %s\n", path)
+						}
+					}
+				}
+			}
+		}
+	}
+	for _, change := range delayChanges {
+		fmt.Fprintf(&entry, "  [~] Crawl-delay of %s: %s\n", change.UserAgent, change)
+	}
+	for _, sitemap := range addedSitemaps {
+		fmt.Fprintf(&entry, "  [+] Sitemap: %s\n", sitemap)
+	}
+	for _, sitemap := range removedSitemaps {
+		fmt.Fprintf(&entry, "  [-] Sitemap: %s\n", sitemap)
+	}
+	t.entries = append(t.entries, entry.String())
+}
+
+// writeAddedRules lists every rule of an agent that is new in the timeline.
+func writeAddedRules(entry *strings.Builder, rules RuleSet) {
+	allows := []string{}
+	disallows := []string{}
+	for path, directive := range rules {
+		if directive == "allow" {
+			allows = append(allows, path)
+		} else {
+			disallows = append(disallows, path)
+		}
+	}
+	sort.Strings(allows)
+	sort.Strings(disallows)
+
+	if len(allows) > 0 {
+		fmt.Fprintln(entry, "    Allow:")
+		for _, path := range allows {
+			fmt.Fprintf(entry, "      + %s\n", path)
+		}
+	}
+	if len(disallows) > 0 {
+		fmt.Fprintln(entry, "    Disallow:")
+		for _, path := range disallows {
+			fmt.Fprintf(entry, "      + %s\n", path)
+		}
+	}
+}
+
+// --- Structs for JSON output ---
+type changeSet struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+type ruleChange struct {
+	UserAgent string    `json:"user_agent"`
+	Allow     changeSet `json:"allow,omitempty"`
+	Disallow  changeSet `json:"disallow,omitempty"`
+}
+
+type timelineEntry struct {
+	ID              string        `json:"id"`
+	Timestamp       string        `json:"timestamp"`
+	Original        string        `json:"original,omitempty"` // robots.txt URL the version was archived under
+	AgentsAdded     []string      `json:"agents_added,omitempty"`
+	AgentsRemoved   []string      `json:"agents_removed,omitempty"`
+	RuleChanges     []ruleChange  `json:"rule_changes,omitempty"`
+	InitialContent  []ruleChange  `json:"initial_content,omitempty"`
+	DelayChanges    []delayChange `json:"crawl_delay_changes,omitempty"`
+	SitemapsAdded   []string      `json:"sitemaps_added,omitempty"`
+	SitemapsRemoved []string      `json:"sitemaps_removed,omitempty"`
+	FlaggedAgents   []string      `json:"flagged_agents,omitempty"`
+}
+
+// timelineFile builds the JSON delta file and the raw robots.txt files of a
+// timeline one version at a time. Raw files are written as soon as a version
+// turns out to be a change; in -year mode they are kept until finish puts
+// them in the zip archive.
+type timelineFile struct {
+	u                string
+	opts             options
+	dirPath          string
+	jsonFilePath     string
+	htmlFilePath     string // Empty unless -format html
+	zipFilePath      string
+	previousRules    AgentRules
+	previousDelays   AgentDelays
+	previousSitemaps []string
+	timeline         []timelineEntry
+	existingEntries  int
+	filesToZip       map[string]string // K: filename, V: content
+}
+
+func newTimelineFile(u string, baseline AgentRules, baselineDelays AgentDelays, baselineSitemaps []string, opts options) (*timelineFile, error) {
+	domain := getHost(u)
+	dirPath := timelineDir(u, opts)
+	baseName := "timeline"
+	if opts.year > 0 {
+		baseName = fmt.Sprintf("timeline_%s", yearLabel(opts))
+	}
+
+	var zipFileName strings.Builder
+	err := opts.zipName.Execute(&zipFileName, struct {
+		Domain string
+		Year   string
+	}{Domain: domain, Year: yearLabel(opts)})
+	if err != nil {
+		return nil, fmt.Errorf("building zip file name: %v", err)
+	}
+
+	t := &timelineFile{
+		u:                u,
+		opts:             opts,
+		dirPath:          dirPath,
+		jsonFilePath:     filepath.Join(dirPath, baseName+".json"),
+		zipFilePath:      filepath.Join(dirPath, zipFileName.String()),
+		previousRules:    baseline,
+		previousDelays:   baselineDelays,
+		previousSitemaps: baselineSitemaps,
+		filesToZip:       make(map[string]string),
+	}
+	if opts.format == "html" {
+		t.htmlFilePath = filepath.Join(dirPath, baseName+".html")
+	}
+
+	// --- In incremental mode, keep what earlier runs already wrote ---
+	if opts.state != nil {
+		if raw, err := ioutil.ReadFile(t.jsonFilePath); err == nil {
+			if err := json.Unmarshal(raw, &t.timeline); err != nil {
+				return nil, fmt.Errorf("reading existing timeline %s: %v", t.jsonFilePath, err)
+			}
+			if opts.reverse {
+				// Back to chronological order so new entries can be appended
+				for i, j := 0, len(t.timeline)-1; i < j; i, j = i+1, j-1 {
+					t.timeline[i], t.timeline[j] = t.timeline[j], t.timeline[i]
+				}
+			}
+		}
+		if zipReader, err := zip.OpenReader(t.zipFilePath); err == nil {
+			for _, f := range zipReader.File {
+				rc, err := f.Open()
+				if err != nil {
+					continue
+				}
+				content, err := ioutil.ReadAll(rc)
+				rc.Close()
+				if err == nil {
+					t.filesToZip[f.Name] = string(content)
+				}
+			}
+			zipReader.Close()
+		}
+	}
+	for i := range t.timeline {
+		if t.timeline[i].ID == "" {
+			// Written before entries had IDs
+			t.timeline[i].ID = changeID(domain, t.timeline[i].Timestamp)
+		}
+	}
+	t.existingEntries = len(t.timeline)
+	return t, nil
+}
+
+// changeID identifies the change-point of domain at timestamp. It is derived
+// from both alone, so repeated runs assign a change the same ID.
+func changeID(domain, timestamp string) string {
+	sum := sha256.Sum256([]byte(domain + "\x00" + timestamp))
+	return hex.EncodeToString(sum[:8])
+}
+
+// add diffs vc against the previous version and records it if it changed.
+func (t *timelineFile) add(vc VersionContent) {
+	previousRules := t.previousRules
+	t.previousRules = vc.Rules
+	delayChanges := diffDelays(vc.Delays, t.previousDelays)
+	t.previousDelays = vc.Delays
+	addedSitemaps, removedSitemaps := diffSitemaps(vc.Sitemaps, t.previousSitemaps)
+	t.previousSitemaps = vc.Sitemaps
+
+	entry := timelineEntry{ID: changeID(getHost(t.u), vc.Timestamp), Timestamp: vc.Timestamp, Original: vc.Original}
+	isMeaningfulChange := false
+
+	if previousRules == nil {
+		// --- Initial version ---
+		if len(vc.Rules) > 0 {
+			isMeaningfulChange = true // The first entry is a change if it has content
+			for agent, rules := range vc.Rules {
+				entry.InitialContent = append(entry.InitialContent, addedRuleChange(agent, rules))
+			}
+		}
+	} else {
+		// --- Compare with previous version ---
+		// Find added agents
+		for agent, rules := range vc.Rules {
+			if _, exists := previousRules[agent]; !exists {
+				entry.AgentsAdded = append(entry.AgentsAdded, agent)
+				// also list the initial rules for the new agent
+				entry.RuleChanges = append(entry.RuleChanges, addedRuleChange(agent, rules))
+				isMeaningfulChange = true
+			}
+		}
+		sort.Strings(entry.AgentsAdded)
+
+		// Find removed agents
+		for agent := range previousRules {
+			if _, exists := vc.Rules[agent]; !exists {
+				entry.AgentsRemoved = append(entry.AgentsRemoved, agent)
+				isMeaningfulChange = true
+			}
+		}
+		sort.Strings(entry.AgentsRemoved)
+
+		// Find rule changes for existing agents
+		for agent, currentRules := range vc.Rules {
+			if prevAgentRules, exists := previousRules[agent]; exists {
+				addedAllows, removedAllows, addedDisallows, removedDisallows := waybackrobots.DiffRuleSets(currentRules, prevAgentRules)
+
+				if len(addedAllows) > 0 || len(removedAllows) > 0 || len(addedDisallows) > 0 || len(removedDisallows) > 0 {
+					change := ruleChange{UserAgent: agent}
+					change.Allow = changeSet{Added: addedAllows, Removed: removedAllows}
+					change.Disallow = changeSet{Added: addedDisallows, Removed: removedDisallows}
+					entry.RuleChanges = append(entry.RuleChanges, change)
+					isMeaningfulChange = true
+				}
+			}
+		}
+	}
+	if len(delayChanges) > 0 {
+		entry.DelayChanges = delayChanges
+		isMeaningfulChange = true
+	}
+	if len(addedSitemaps) > 0 || len(removedSitemaps) > 0 {
+		entry.SitemapsAdded, entry.SitemapsRemoved = addedSitemaps, removedSitemaps
+		isMeaningfulChange = true
+	}
+	if !isMeaningfulChange {
+		return
+	}
+
+	// --- Save the raw .txt file content of the first version and of every change ---
+	if vc.RawContent != "" {
+		fileName := fmt.Sprintf("robots_%s.txt", vc.Timestamp)
+		if t.opts.year > 0 {
+			// If year is specified, add to zip map instead of writing directly
+			t.filesToZip[fileName] = vc.RawContent
+		} else if err := os.MkdirAll(t.dirPath, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", t.dirPath, err)
+		} else {
+			rawFilePath := filepath.Join(t.dirPath, fileName)
+			if err := ioutil.WriteFile(rawFilePath, []byte(vc.RawContent), 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing raw file %s: %v\n", rawFilePath, err)
+			}
+		}
+	}
+
+	entry.FlaggedAgents = flaggedAgents(vc.Rules, previousRules, t.opts.flagAgents)
+	t.timeline = append(t.timeline, entry)
+}
+
+// finish writes the zip archive (in -year mode), the JSON timeline and, with
+// -format html, the HTML report. It reports whether the archive and the JSON
+// timeline were written.
+func (t *timelineFile) finish() bool {
+	if err := os.MkdirAll(t.dirPath, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", t.dirPath, err)
+		return false
+	}
+
+	// --- Write the collected .txt files to a zip archive if year is specified ---
+	if t.opts.year > 0 && len(t.filesToZip) > 0 {
+		var zipBuffer bytes.Buffer
+		zipWriter := zip.NewWriter(&zipBuffer)
+
+		for name, content := range t.filesToZip {
+			f, err := zipWriter.Create(name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error adding file %s to zip: %v\n", name, err)
+				continue
+			}
+			_, err = f.Write([]byte(content))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing content for file %s to zip: %v\n", name, err)
+				continue
+			}
+		}
+		if err := zipWriter.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating zip file %s: %v\n", t.zipFilePath, err)
+			return false
+		}
+		if err := writeFileAtomic(t.zipFilePath, zipBuffer.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating zip file %s: %v\n", t.zipFilePath, err)
+			return false
+		}
+		infof("Wrote %d txt files to %s\n", len(t.filesToZip), t.zipFilePath)
+	}
+
+	// --- Write the JSON timeline.json file ---
+	// Only write the file if there's something to write
+	if len(t.timeline) > t.existingEntries {
+		if t.opts.reverse {
+			for i, j := 0, len(t.timeline)-1; i < j; i, j = i+1, j-1 {
+				t.timeline[i], t.timeline[j] = t.timeline[j], t.timeline[i]
+			}
+		}
+
+		if err := writeJSONFile(t.jsonFilePath, t.timeline); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing JSON to %s: %v\n", t.jsonFilePath, err)
+			return false
+		}
+		infof("Wrote timeline to %s\n", t.jsonFilePath)
+		if t.htmlFilePath != "" {
+			writeTimelineHTML(t.htmlFilePath, t.u, t.timeline)
+		}
+	} else {
+		infof("No meaningful changes found for %s in %s. No timeline file written.\n", t.u, yearLabel(t.opts))
+	}
+	return true
+}
+
+// addedRuleChange lists every rule of an agent as added.
+func addedRuleChange(agent string, rules RuleSet) ruleChange {
+	allows := []string{}
+	disallows := []string{}
+	for path, directive := range rules {
+		if directive == "allow" {
+			allows = append(allows, path)
+		} else {
+			disallows = append(disallows, path)
+		}
+	}
+	sort.Strings(allows)
+	sort.Strings(disallows)
+
+	change := ruleChange{UserAgent: agent}
+	if len(allows) > 0 {
+		change.Allow.Added = allows
+	}
+	if len(disallows) > 0 {
+		change.Disallow.Added = disallows
+	}
+	return change
+}
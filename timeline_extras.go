@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// pathLifespan records when a disallowed path was present in robots.txt.
+type pathLifespan struct {
+	URL       string `json:"url"`
+	FirstSeen string `json:"first_seen"`
+	LastSeen  string `json:"last_seen"`
+	InLatest  bool   `json:"in_latest"`
+}
+
+// lifespanTracker records, for every path that was disallowed for any agent,
+// the first and last version it appeared in. Versions must be added in
+// timestamp order.
+type lifespanTracker struct {
+	lifespans map[string]*pathLifespan
+	latest    map[string]bool
+}
+
+func newLifespanTracker() *lifespanTracker {
+	return &lifespanTracker{lifespans: make(map[string]*pathLifespan)}
+}
+
+func (t *lifespanTracker) add(vc VersionContent) {
+	disallowed := make(map[string]bool)
+	for _, rules := range vc.Rules {
+		for path, directive := range rules {
+			if directive == "disallow" {
+				disallowed[path] = true
+			}
+		}
+	}
+
+	for path := range disallowed {
+		ls, exists := t.lifespans[path]
+		if !exists {
+			ls = &pathLifespan{URL: path, FirstSeen: vc.Timestamp}
+			t.lifespans[path] = ls
+		}
+		ls.LastSeen = vc.Timestamp
+	}
+	t.latest = disallowed
+}
+
+func (t *lifespanTracker) result() []pathLifespan {
+	result := make([]pathLifespan, 0, len(t.lifespans))
+	for path, ls := range t.lifespans {
+		ls.InLatest = t.latest[path]
+		result = append(result, *ls)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].URL < result[j].URL
+	})
+	return result
+}
+
+// writeTimelineJSON writes v to fileName next to the timeline output, or
+// prints the JSON to stdout when no output directory is set.
+func writeTimelineJSON(u string, fileName string, v interface{}, opts options) {
+	if opts.outputDir == "" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(v); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing JSON for %s: %v\n", u, err)
+		}
+		return
+	}
+
+	dirPath := timelineDir(u, opts)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", dirPath, err)
+		return
+	}
+
+	filePath := filepath.Join(dirPath, fileName)
+	if err := writeJSONFile(filePath, v); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing JSON to %s: %v\n", filePath, err)
+	} else {
+		infof("Wrote %s\n", filePath)
+	}
+}
+
+// orderedVersion is the file-ordered rules of a single version.
+type orderedVersion struct {
+	Timestamp string     `json:"timestamp"`
+	Agents    AgentOrder `json:"agents"`
+}
+
+// flip is a version in which a path took on a new directive.
+type flip struct {
+	Timestamp string `json:"timestamp"`
+	Directive string `json:"directive"`
+}
+
+// flippedPath is a path whose directive for an agent changed at least once.
+type flippedPath struct {
+	Agent   string `json:"agent"`
+	URL     string `json:"url"`
+	History []flip `json:"history"`
+}
+
+// flipTracker finds the paths whose directive went from Allow to Disallow or
+// back for some agent. Versions must be added in timestamp order. The history
+// starts with the first directive seen and lists every change after it.
+// Versions in which the path is absent don't count as a change.
+type flipTracker struct {
+	histories map[flipKey][]flip
+}
+
+type flipKey struct{ agent, path string }
+
+func newFlipTracker() *flipTracker {
+	return &flipTracker{histories: make(map[flipKey][]flip)}
+}
+
+func (t *flipTracker) add(vc VersionContent) {
+	for agent, rules := range vc.Rules {
+		for path, directive := range rules {
+			k := flipKey{agent, path}
+			history := t.histories[k]
+			if len(history) > 0 && history[len(history)-1].Directive == directive {
+				continue
+			}
+			t.histories[k] = append(history, flip{Timestamp: vc.Timestamp, Directive: directive})
+		}
+	}
+}
+
+func (t *flipTracker) result() []flippedPath {
+	result := make([]flippedPath, 0)
+	for k, history := range t.histories {
+		if len(history) > 1 {
+			result = append(result, flippedPath{Agent: k.agent, URL: k.path, History: history})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].URL != result[j].URL {
+			return result[i].URL < result[j].URL
+		}
+		return result[i].Agent < result[j].Agent
+	})
+	return result
+}
+
+// ruleCount is the number of rules an agent had in a single version.
+type ruleCount struct {
+	Timestamp     string `json:"timestamp"`
+	Agent         string `json:"agent"`
+	AllowCount    int    `json:"allow_count"`
+	DisallowCount int    `json:"disallow_count"`
+}
+
+// countRules returns a ruleCount per agent of vc, sorted by agent.
+func countRules(vc VersionContent) []ruleCount {
+	counts := make([]ruleCount, 0, len(vc.Rules))
+	for agent, rules := range vc.Rules {
+		count := ruleCount{Timestamp: vc.Timestamp, Agent: agent}
+		for _, directive := range rules {
+			if directive == "allow" {
+				count.AllowCount++
+			} else {
+				count.DisallowCount++
+			}
+		}
+		counts = append(counts, count)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Agent < counts[j].Agent
+	})
+	return counts
+}
+
+// onlyAgents keeps the rules and Crawl-delays of the agents named by -agent,
+// matched case-insensitively, so changes to other agents never show up in
+// the timeline. An empty list keeps every agent.
+func onlyAgents(vc VersionContent, agents []string) VersionContent {
+	if len(agents) == 0 {
+		return vc
+	}
+	rules := make(AgentRules)
+	order := make(AgentOrder)
+	delays := make(AgentDelays)
+	for agent, ruleSet := range vc.Rules {
+		if isListedAgent(agent, agents) {
+			rules[agent] = ruleSet
+		}
+	}
+	for agent, orderedRules := range vc.Order {
+		if isListedAgent(agent, agents) {
+			order[agent] = orderedRules
+		}
+	}
+	for agent, delay := range vc.Delays {
+		if isListedAgent(agent, agents) {
+			delays[agent] = delay
+		}
+	}
+	vc.Rules, vc.Order, vc.Delays = rules, order, delays
+	return vc
+}
+
+// isListedAgent reports whether agent is one of the lowercased names.
+func isListedAgent(agent string, names []string) bool {
+	agent = strings.ToLower(agent)
+	for _, name := range names {
+		if agent == name {
+			return true
+		}
+	}
+	return false
+}
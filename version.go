@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Build metadata, set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// versionString falls back to the VCS information the Go toolchain embeds
+// for whatever isn't set.
+var (
+	version   = "dev" // The release of the tool
+	commit    = ""
+	buildDate = ""
+)
+
+// versionString describes the build for -version, e.g.
+// "waybackrobots v1.2.0 (commit 1a2b3c4, built 2024-05-01T12:00:00Z)".
+func versionString() string {
+	rev, date := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && rev == "":
+				rev = setting.Value
+				if len(rev) > 7 {
+					rev = rev[:7]
+				}
+			case setting.Key == "vcs.time" && date == "":
+				date = setting.Value
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return fmt.Sprintf("waybackrobots %s (commit %s, built %s)", version, rev, date)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// expandWildcard turns a robots.txt pattern into the literal path it covers,
+// for tools that need real URLs rather than globs:
+//
+//	/admin/*.php$  -> /admin/      (cut at the first "*")
+//	/search*q=     -> /search
+//	/ebooks?*q=*   -> /ebooks      (a dangling "?" is dropped with the wildcard)
+//	/page.html$    -> /page.html   ("$" only anchors the end, so it is removed)
+//	/a$b           -> /a$b         ("$" anywhere else is a literal character)
+//
+// Paths without wildcards are returned unchanged.
+func expandWildcard(rawURL string) string {
+	start := 0
+	if i := strings.Index(rawURL, "://"); i >= 0 {
+		start = i + 3
+		if j := strings.Index(rawURL[start:], "/"); j >= 0 {
+			start += j
+		} else {
+			return rawURL // No path
+		}
+	}
+
+	expanded := rawURL
+	if i := strings.Index(rawURL[start:], "*"); i >= 0 {
+		expanded = strings.TrimSuffix(rawURL[:start+i], "?")
+	} else {
+		expanded = strings.TrimSuffix(rawURL, "$")
+	}
+	if len(expanded) <= start {
+		return rawURL[:start] + "/" // The pattern covered the whole site
+	}
+	return expanded
+}
+
+// wildcardPattern records the raw pattern an expanded path came from.
+type wildcardPattern struct {
+	Pattern string `json:"pattern"`
+	Path    string `json:"path"`
+}
+
+// writeWildcardPatterns writes the patterns -expand-wildcards rewrote for u
+// to wildcard_patterns.json next to paths.json, so the raw globs aren't lost.
+func writeWildcardPatterns(u string, patterns map[string]string, opts options) {
+	if len(patterns) == 0 {
+		return
+	}
+	dirPath := filepath.Join(opts.outputDir, getHost(u))
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", dirPath, err)
+		return
+	}
+
+	list := make([]wildcardPattern, 0, len(patterns))
+	for pattern, path := range patterns {
+		list = append(list, wildcardPattern{Pattern: pattern, Path: path})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Pattern < list[j].Pattern
+	})
+
+	filePath := filepath.Join(dirPath, "wildcard_patterns.json")
+	if err := writeJSONFile(filePath, list); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing JSON to %s: %v\n", filePath, err)
+	} else {
+		infof("Wrote wildcard patterns to %s\n", filePath)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// output performs every per-domain write when set, see outputWriter.
+var output *outputWriter
+
+// outputWriter runs all disk writes and result printing on a single goroutine,
+// so domain workers never contend on files or interleave their output.
+type outputWriter struct {
+	jobs chan func()
+	done chan struct{}
+}
+
+func newOutputWriter() *outputWriter {
+	w := &outputWriter{
+		jobs: make(chan func(), 64),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		for job := range w.jobs {
+			job()
+		}
+	}()
+	return w
+}
+
+// submit queues fn to run on the writer goroutine. On a nil writer fn runs
+// right away on the caller's goroutine.
+func (w *outputWriter) submit(fn func()) {
+	if w == nil {
+		fn()
+		return
+	}
+	w.jobs <- fn
+}
+
+// close waits for every queued write to finish.
+func (w *outputWriter) close() {
+	if w == nil {
+		return
+	}
+	close(w.jobs)
+	<-w.done
+}
+
+// writeJSONFile writes v as indented JSON to filePath. The JSON goes to a
+// temporary file that is renamed into place, so readers never see a partial file.
+func writeJSONFile(filePath string, v interface{}) error {
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	raw = append(raw, '\n')
+	return writeFileAtomic(filePath, raw)
+}
+
+// writeFileAtomic writes content to a temporary file next to filePath and
+// renames it into place.
+func writeFileAtomic(filePath string, content []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), filePath)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// apexURL strips a leading "www." from the host of a base URL.
+func apexURL(u string) string {
+	return strings.Replace(u, "://www.", "://", 1)
+}
+
+// wwwURL returns the www. sibling of an apex base URL.
+func wwwURL(u string) string {
+	return strings.Replace(apexURL(u), "://", "://www.", 1)
+}
+
+// rehostURL moves a URL found under the www. sibling of u onto u itself.
+func rehostURL(rawURL, u string) string {
+	www := wwwURL(u)
+	if strings.HasPrefix(rawURL, www) {
+		return u + strings.TrimPrefix(rawURL, www)
+	}
+	return rawURL
+}
+
+// dedupeWWW drops inputs whose www./apex counterpart was already seen.
+func dedupeWWW(urls []string) []string {
+	seen := make(map[string]bool)
+	deduped := make([]string, 0, len(urls))
+	skipped := 0
+	for _, rawURL := range urls {
+		u, err := cleanURL(rawURL)
+		if err != nil {
+			// Leave it to processDomain to report the bad input
+			deduped = append(deduped, rawURL)
+			continue
+		}
+		host := getHost(apexURL(u))
+		if seen[host] {
+			skipped++
+			continue
+		}
+		seen[host] = true
+		deduped = append(deduped, rawURL)
+	}
+	if skipped > 0 {
+		infof("Merged %d www/apex duplicate targets\n", skipped)
+	}
+	return deduped
+}
+
+// hostMerger folds the versions of the apex and www. hosts, fed to merge in
+// timestamp order, into a single history under u. Each merged version holds
+// the union of both hosts' latest rules as of its timestamp, so divergent
+// robots.txt files never show up as flip-flopping changes. When the hosts
+// disagree on the directive for a path, disallow wins, and on the
+// Crawl-delay of an agent, the longer delay wins. The file order of an
+// agent's rules lists u's own rules first, then those only the other host has.
+type hostMerger struct {
+	u            string
+	latest       map[string]AgentRules  // Key: host base URL
+	latestOrder  map[string]AgentOrder  // Key: host base URL
+	latestDelays map[string]AgentDelays // Key: host base URL
+
+	latestSitemaps map[string][]string // Key: host base URL
+}
+
+func newHostMerger(u string) *hostMerger {
+	return &hostMerger{u: u, latest: make(map[string]AgentRules), latestOrder: make(map[string]AgentOrder), latestDelays: make(map[string]AgentDelays), latestSitemaps: make(map[string][]string)}
+}
+
+func (m *hostMerger) merge(vc VersionContent) VersionContent {
+	rules := make(AgentRules)
+	for agent, ruleSet := range vc.Rules {
+		rules[agent] = make(RuleSet)
+		for path, directive := range ruleSet {
+			rules[agent][rehostURL(path, m.u)] = directive
+		}
+	}
+	m.latest[vc.URL] = rules
+
+	combined := make(AgentRules)
+	for _, hostRules := range m.latest {
+		for agent, ruleSet := range hostRules {
+			if _, ok := combined[agent]; !ok {
+				combined[agent] = make(RuleSet)
+			}
+			for path, directive := range ruleSet {
+				if combined[agent][path] != "disallow" {
+					combined[agent][path] = directive
+				}
+			}
+		}
+	}
+
+	hostOrder := make(AgentOrder)
+	for agent, orderedRules := range vc.Order {
+		for _, rule := range orderedRules {
+			rule.Path = rehostURL(rule.Path, m.u)
+			hostOrder[agent] = append(hostOrder[agent], rule)
+		}
+	}
+	m.latestOrder[vc.URL] = hostOrder
+
+	hosts := make([]string, 0, len(m.latestOrder))
+	for host := range m.latestOrder {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		// u first, so its own file order leads
+		if (hosts[i] == m.u) != (hosts[j] == m.u) {
+			return hosts[i] == m.u
+		}
+		return hosts[i] < hosts[j]
+	})
+	order := make(AgentOrder)
+	inOrder := make(map[string]map[OrderedRule]bool) // Key: agent
+	for _, host := range hosts {
+		for agent, orderedRules := range m.latestOrder[host] {
+			if inOrder[agent] == nil {
+				inOrder[agent] = make(map[OrderedRule]bool)
+			}
+			for _, rule := range orderedRules {
+				if !inOrder[agent][rule] {
+					inOrder[agent][rule] = true
+					order[agent] = append(order[agent], rule)
+				}
+			}
+		}
+	}
+
+	m.latestDelays[vc.URL] = vc.Delays
+	delays := make(AgentDelays)
+	for _, hostDelays := range m.latestDelays {
+		for agent, delay := range hostDelays {
+			if longerDelay(delay, delays[agent]) {
+				delays[agent] = delay
+			}
+		}
+	}
+
+	m.latestSitemaps[vc.URL] = vc.Sitemaps
+	seen := make(map[string]bool)
+	sitemaps := []string{}
+	for _, hostSitemaps := range m.latestSitemaps {
+		for _, sitemap := range hostSitemaps {
+			sitemap = rehostURL(sitemap, m.u)
+			if !seen[sitemap] {
+				seen[sitemap] = true
+				sitemaps = append(sitemaps, sitemap)
+			}
+		}
+	}
+	sort.Strings(sitemaps)
+
+	return VersionContent{
+		Timestamp:  vc.Timestamp,
+		URL:        m.u,
+		Original:   vc.Original,
+		Rules:      combined,
+		Order:      order,
+		Delays:     delays,
+		Sitemaps:   sitemaps,
+		RawContent: vc.RawContent,
+	}
+}
+
+// longerDelay reports whether the Crawl-delay a is longer than b. A delay
+// that isn't a number only wins over a missing one.
+func longerDelay(a, b string) bool {
+	if b == "" {
+		return true
+	}
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	return errA == nil && (errB != nil || x > y)
+}